@@ -1,8 +1,15 @@
 package rgx
 
-func getChar(input string, pos int) uint8 {
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func getChar(input string, pos int) symbol {
 	if pos >= 0 && pos < len(input) {
-		return input[pos]
+		return symbol(input[pos])
 	}
 
 	if pos >= len(input) {
@@ -13,7 +20,7 @@ func getChar(input string, pos int) uint8 {
 }
 
 // get the next state given the 'ch' as an input
-func (s *State) nextStateWith(ch uint8) *State {
+func (s *State) nextStateWith(ch symbol) *State {
 	states := s.transitions[ch]
 	if len(states) == 0 {
 		return nil
@@ -21,121 +28,471 @@ func (s *State) nextStateWith(ch uint8) *State {
 	return states[0]
 }
 
+// search looks for the leftmost match of this NFA that starts between the positions 'from' and 'to'.
+// approximate patterns first find the leftmost match with the whole budget of edits,
+// and then a match with fewer edits that starts before its end is preferred, with the fewest edits first,
+// e.g., (?e<=1)hello finds "hello" in "xhello", not "xhello", but still finds "helo" in "helo hello"
+func (s *State) search(inputString string, from, to int, ctx *regexCheckContext) bool {
+	// the last attempt is only limited by the budgets of the approximate parts themselves
+	if !s.searchWithLimit(inputString, from, to, -1, ctx) {
+		return false
+	}
+	if s.maxEdits == 0 {
+		return true
+	}
+
+	matched, _ := ctx.topCapture("0")
+	start, end := matched.start, matched.end
+	if end > to {
+		end = to
+	}
+	for limit := 0; limit < s.maxEdits; limit++ {
+		if s.searchWithLimit(inputString, from, end, limit, ctx) {
+			return true
+		}
+	}
+	return s.searchWithLimit(inputString, start, start, -1, ctx)
+}
+
+// searchWithLimit looks for the leftmost match that needs at most 'limit' edits, -1 means no limit
+func (s *State) searchWithLimit(inputString string, from, to, limit int, ctx *regexCheckContext) bool {
+	ctx.editLimit = limit
+	ctx.matched = false
+	for pos := from; pos <= to; pos++ {
+		if s.checkLeftmost(inputString, pos, ctx) {
+			return true
+		}
+		// if we haven't matched,
+		// then we need to move on to the next character
+	}
+	return false
+}
+
+// matchAt checks if there's a match of this NFA that starts exactly at pos.
+// approximate patterns are checked with an increasing limit of edits,
+// so the match that needs the fewest edits is the one that is found
+func (s *State) matchAt(inputString string, pos int, ctx *regexCheckContext) bool {
+	return s.search(inputString, pos, pos, ctx)
+}
+
+// checkLeftmost checks if there's a match starting at pos.
+// in the longest mode, all the paths are run at the same time, or explored one by one if the pattern isn't regular,
+// and the groups of the best match are restored
+func (s *State) checkLeftmost(inputString string, pos int, ctx *regexCheckContext) bool {
+	ctx.history = s.history
+	if !s.longest {
+		return s.check(inputString, pos, ctx)
+	}
+
+	ctx.best = nil
+	if s.regular {
+		s.longestMatch(inputString, pos, ctx)
+	} else {
+		ctx.longest = true
+		s.check(inputString, pos, ctx)
+	}
+	if ctx.best == nil {
+		return false
+	}
+
+	ctx.groups = map[string][]*capture{}
+	for groupName, captures := range ctx.best {
+		for i := range captures {
+			ctx.pushCapture(groupName, &captures[i])
+		}
+	}
+	ctx.trail = ctx.bestTrail
+	return true
+}
+
 // checks if the inputString is accepted by this NFA
 // pos - starting position in the string
-// started - have we started matching characters? it's useful when we need to skip characters before starting to match
 // ctx - the context for this particular check, the groups, etc.
-func (s *State) check(inputString string, pos int, started bool, ctx *regexCheckContext) bool {
+func (s *State) check(inputString string, pos int, ctx *regexCheckContext) (accepted bool) {
+	if s.fuzzy != nil {
+		if s.fuzzy.enter {
+			// entering an approximate part, it has its own budget of edits
+			ctx.budgets = append(ctx.budgets, &editBudget{max: s.fuzzy.maxEdits})
+			defer func() {
+				ctx.budgets = ctx.budgets[:len(ctx.budgets)-1]
+			}()
+		} else {
+			// leaving an approximate part, restore its budget if we need to backtrack into it
+			budget := ctx.budgets[len(ctx.budgets)-1]
+			ctx.budgets = ctx.budgets[:len(ctx.budgets)-1]
+			defer func() {
+				ctx.budgets = append(ctx.budgets, budget)
+			}()
+		}
+	}
+
 	if s.groups != nil {
 		// if this state has groups associated with it
-		// go through each group
-		for _, capturedGroup := range s.groups {
-			// if it's a start of a group
-			if capturedGroup.start {
-				c := &capture{
-					start: pos,
-					end:   -1,
-				}
-				// for each name of the captured group
-				// add the capture object
-				// a group can have 2 different names: numeric (\1) and user-set (\k<animal>)
-				for _, groupName := range capturedGroup.names {
-					ctx.groups[groupName] = c
-				}
-			}
-
-			// if the group ends
-			if capturedGroup.end {
-				// for each name of the captured group
-				// set the end to the current position
-				// a group can have 2 different names: numeric (\1) and user-set (\k<animal>)
-				for _, groupName := range capturedGroup.names {
-					if ctx.groups[groupName].end < pos {
-						// only update if the new position is greater
-						ctx.groups[groupName].end = pos
-					}
-				}
-			}
+		// apply them, and revert the changes if this path fails
+		revert, ok := s.updateGroups(pos, ctx)
+		if !ok {
+			return false
 		}
+		defer func() {
+			if !accepted {
+				revert()
+			}
+		}()
 	}
 
 	currentChar := getChar(inputString, pos)
 
-	// the current character should be either EOF or
-	// the next one after that a newline to be valid, otherwise check fails
-	if s.endOfText && (currentChar != endOfText && currentChar != newline) {
+	previousChar := getChar(inputString, pos-1)
+
+	// the current character should be either EOF or,
+	// in the multiline mode, a newline to be valid, otherwise check fails
+	if s.endOfText && currentChar != endOfText && !(s.multiline && s.isLineEnd(inputString, pos)) {
 		return false
 	}
 
-	previousChar := getChar(inputString, pos-1)
-	// the current character should be either Start of File or
-	// the previous one before that a newline to be valid, otherwise check fails
-	if s.startOfText && (currentChar != startOfText && previousChar != newline) {
+	// the previous character should be either Start of File or,
+	// in the multiline mode, a newline to be valid, otherwise check fails
+	if s.startOfText && previousChar != startOfText && !(s.multiline && s.isLineStart(inputString, pos)) {
 		return false
 	}
 
 	if s.terminal {
+		// MatchFull only accepts the match that ends at the end of the input
+		if ctx.spanEnd >= 0 && pos != ctx.spanEnd {
+			return false
+		}
+		if ctx.maxEnd >= 0 && pos > ctx.maxEnd {
+			return false
+		}
+		if ctx.longest {
+			// keep looking for a better match, this path is reverted as any failed one
+			ctx.recordLongest()
+			return false
+		}
+		if ctx.ends != nil {
+			// keep looking for the other ends
+			ctx.ends[pos] = true
+			return false
+		}
+		if !ctx.matched {
+			ctx.matched = true
+			ctx.editsAtMatch = ctx.edits
+		}
 		return true
 	}
 
 	// if there's a backreference transition
 	if s.backreference != nil {
 		// get the captured reference
-		captured, found := ctx.groups[s.backreference.name]
+		captured, found := ctx.matchedCapture(s.backreference.name)
 		if !found {
 			return false
 		}
 		// get the string value of it
 		capturedString := captured.string(inputString)
-		size := len(capturedString)
-		backreferenceCheckFailed := false
-		for i := 0; i < size; i++ {
-			// see if matches with the next set of characters
-			if pos >= len(inputString) || inputString[pos+i] != capturedString[i] {
-				backreferenceCheckFailed = true
-				break
-			}
-		}
-		if !backreferenceCheckFailed {
-			return s.backreference.target.check(inputString, pos+size, true, ctx)
+		if size, ok := s.backreference.matchAt(inputString, pos, capturedString); ok {
+			return s.backreference.target.check(inputString, pos+size, ctx)
 		}
 		// backreference check failed, let's see if
 		// there are any other transitions we can use
 	}
 
-	nextState := s.nextStateWith(currentChar)
+	// if the path depends on whether a group has been captured
+	if s.condition != nil {
+		_, captured := ctx.matchedCapture(s.condition.name)
+		if captured {
+			return s.condition.yes.check(inputString, pos, ctx)
+		}
+		return s.condition.no.check(inputString, pos, ctx)
+	}
+
+	// if there's a span operator transition (extended syntax)
+	if s.operator != nil {
+		if s.operator.check(inputString, pos, ctx) {
+			return true
+		}
+	}
+
+	// the next iteration of a greedy quantifier is tried first
+	if s.loop != nil && s.checkLoop(inputString, pos, ctx) {
+		return true
+	}
+
+	nextState, nextPos := s.nextStateWith(currentChar), pos+1
 	// if there are no transitions for the current char as is
-	// then see if there's a transition for any char, i.e. dot (.) sign
-	if nextState == nil && (currentChar != endOfText && currentChar != newline) {
-		nextState = s.nextStateWith(anyChar)
+	// then see if the character class accepts it, e.g., a bracket or the dot (.) sign
+	if nextState == nil && s.class != nil {
+		if size, ok := s.class.match(inputString, pos); ok {
+			nextState, nextPos = s.class.target, pos+size
+		}
+	}
+
+	if nextState != nil && nextState.check(inputString, nextPos, ctx) {
+		return true
 	}
 
-	result := nextState != nil && nextState.check(inputString, pos+1, true, ctx)
+	// the epsilon transitions are tried in order, the first path that
+	// reaches the terminal state wins, so the captures are the ones of that path
 	for _, state := range s.transitions[epsilonChar] {
-		// we need to evaluate all the epsilon transitions
-		// because there's a chance that we'll finish early
-		// while there's still more to process
-		result = state.check(inputString, pos, true, ctx) || result
-		result = (currentChar == startOfText && state.check(inputString, pos+1, true, ctx)) || result
+		if state.check(inputString, pos, ctx) {
+			return true
+		}
+	}
+
+	if s.fuzzy == nil && ctx.canEdit() {
+		return s.checkWithEdit(inputString, pos, ctx)
+	}
+
+	return false
+}
+
+// checkLoop starts the next iteration of the quantifier, unless the last iteration
+// has not consumed anything, which would repeat forever
+func (s *State) checkLoop(inputString string, pos int, ctx *regexCheckContext) bool {
+	previous, hasPrevious := ctx.loopPositions[s]
+	if hasPrevious && previous == pos {
+		return false
+	}
+
+	ctx.loopPositions[s] = pos
+	defer func() {
+		if hasPrevious {
+			ctx.loopPositions[s] = previous
+		} else {
+			delete(ctx.loopPositions, s)
+		}
+	}()
+
+	return s.loop.check(inputString, pos, ctx)
+}
+
+// matchAt checks if the captured string is repeated in the input at pos,
+// and returns the number of bytes it takes there, which can differ from
+// the captured string when the case is ignored in the Unicode mode
+func (b *backreference) matchAt(inputString string, pos int, capturedString string) (int, bool) {
+	if !b.caseInsensitive {
+		if strings.HasPrefix(inputString[pos:], capturedString) {
+			return len(capturedString), true
+		}
+		return 0, false
+	}
+
+	end := pos
+	for i := 0; i < len(capturedString); {
+		if end >= len(inputString) {
+			return 0, false
+		}
+
+		expected, expectedSize := rune(capturedString[i]), 1
+		actual, actualSize := rune(inputString[end]), 1
+		if b.unicode {
+			expected, expectedSize = utf8.DecodeRuneInString(capturedString[i:])
+			actual, actualSize = utf8.DecodeRuneInString(inputString[end:])
+		}
+
+		if !equalFold(expected, actual, b.unicode) {
+			return 0, false
+		}
+		i += expectedSize
+		end += actualSize
+	}
+	return end - pos, true
+}
+
+// isLineEnd checks if a line ends at pos. in the CRLF mode, \r\n, \n and \r alone are the line terminators,
+// the line ends before them, and the position between \r and \n is not a line end
+func (s *State) isLineEnd(inputString string, pos int) bool {
+	currentChar := getChar(inputString, pos)
+	if s.crlf {
+		if currentChar == '\r' {
+			return true
+		}
+		if currentChar == newline {
+			return getChar(inputString, pos-1) != '\r'
+		}
+	}
+	return currentChar == newline
+}
+
+// isLineStart checks if a line starts at pos, i.e., it's right after a line terminator
+func (s *State) isLineStart(inputString string, pos int) bool {
+	previousChar := getChar(inputString, pos-1)
+	if s.crlf && previousChar == '\r' {
+		return getChar(inputString, pos) != newline
+	}
+	return previousChar == newline
+}
+
+// updateGroups applies the group boundaries of this state to the captured groups.
+// it returns a function that reverts the changes, and false if a balancing group has nothing to pop
+func (s *State) updateGroups(pos int, ctx *regexCheckContext) (func(), bool) {
+	var undo []func()
+	revert := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+
+	for _, capturedGroup := range s.groups {
+		// if it's a start of a group
+		if capturedGroup.start {
+			// for each name of the captured group
+			// push a new capture object
+			// a group can have 2 different names: numeric (\1) and user-set (\k<animal>)
+			for _, groupName := range capturedGroup.names {
+				groupName := groupName
+				ctx.pushCapture(groupName, &capture{
+					start: pos,
+					end:   -1,
+				})
+				undo = append(undo, func() {
+					ctx.popCapture(groupName)
+				})
+			}
+			if ctx.history && len(capturedGroup.names) > 0 {
+				undo = append(undo, ctx.addEvent(groupEvent{
+					number: capturedGroup.number,
+					start:  true,
+				}))
+			}
+		}
+
+		// if the group ends
+		if capturedGroup.end {
+			// a balancing group pops the latest capture of the other group
+			// and fails if there's nothing to pop
+			var balanced *capture
+			if len(capturedGroup.balances) > 0 {
+				balancedName := capturedGroup.balances[0]
+				popped, ok := ctx.popCapture(balancedName)
+				if !ok {
+					revert()
+					return nil, false
+				}
+				undo = append(undo, func() {
+					ctx.pushCapture(balancedName, popped)
+				})
+				balanced = popped
+
+				// the other names of the group lose the same capture, e.g., the number of a named group.
+				// a name can be shared by several groups, so only the alias that has the same capture is popped
+				for _, alias := range capturedGroup.balances[1:] {
+					alias := alias
+					top, found := ctx.topCapture(alias)
+					if !found || top.start != popped.start || top.end != popped.end {
+						continue
+					}
+					ctx.popCapture(alias)
+					undo = append(undo, func() {
+						ctx.pushCapture(alias, top)
+					})
+				}
+			}
+
+			// for each name of the captured group
+			// set the end to the current position
+			for _, groupName := range capturedGroup.names {
+				captured, found := ctx.topCapture(groupName)
+				if !found {
+					continue
+				}
+				previous := *captured
+				undo = append(undo, func() {
+					*captured = previous
+				})
+
+				if balanced != nil {
+					// a balancing group captures what's between the popped capture and itself
+					captured.start, captured.end = balanced.end, captured.start
+					if captured.start > captured.end {
+						captured.start, captured.end = captured.end, captured.start
+					}
+				} else {
+					captured.end = pos
+				}
+			}
+
+			if ctx.history && len(capturedGroup.names) > 0 {
+				captured, _ := ctx.topCapture(capturedGroup.names[0])
+				undo = append(undo, ctx.addEvent(groupEvent{
+					number:   capturedGroup.number,
+					captured: *captured,
+				}))
+			}
+		}
 	}
 
-	if result {
+	return revert, true
+}
+
+// checkWithEdit tries to continue the match by spending one edit:
+// an extra character in the input (insertion), a different character in the input (substitution)
+// or a character that is missing from the input (deletion)
+func (s *State) checkWithEdit(inputString string, pos int, ctx *regexCheckContext) bool {
+	ctx.useEdit()
+	defer ctx.releaseEdit()
+
+	hasInputLeft := pos < len(inputString)
+
+	if hasInputLeft && s.check(inputString, pos+1, ctx) {
 		return true
 	}
 
-	// if we haven't started matching,
-	// then we need to move on to the next character
-	// while staying in the same state
-	if !started && pos+1 < len(inputString) {
-		return s.check(inputString, pos+1, false, ctx)
+	for _, target := range s.consumingTargets() {
+		if hasInputLeft && target.check(inputString, pos+1, ctx) {
+			return true
+		}
+		if target.check(inputString, pos, ctx) {
+			return true
+		}
 	}
 
 	return false
 }
 
+// consumingTargets returns the states that can be reached by consuming a character
+func (s *State) consumingTargets() []*State {
+	var chars []int
+	for ch := range s.transitions {
+		if ch != epsilonChar {
+			chars = append(chars, int(ch))
+		}
+	}
+	// the order of the map is random, but the result must not be
+	sort.Ints(chars)
+
+	var targets []*State
+	seen := map[*State]bool{}
+	if s.class != nil {
+		seen[s.class.target] = true
+		targets = append(targets, s.class.target)
+	}
+	for _, ch := range chars {
+		for _, target := range s.transitions[symbol(ch)] {
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets
+}
+
 type Result struct {
-	Matches bool
-	Groups  map[string]string
+	Matches    bool
+	Groups     map[string]string
+	Submatches []string // the groups by their numbers, 0 is the whole match, empty if the group hasn't matched
+	// every capture of the groups in order, e.g., one for each iteration of a repeated group.
+	// it's only set if the pattern is compiled with Options.CaptureHistory
+	Captures map[string][]string
+	Edits    int // the number of edits the match needed, only approximate patterns can have edits
+}
+
+// Group returns the text captured by the group with the given number or name,
+// and false if the group has not taken part in the match, unlike a group that has matched an empty string
+func (r Result) Group(groupName string) (string, bool) {
+	text, ok := r.Groups[groupName]
+	return text, ok
 }
 
 type capture struct {
@@ -144,20 +501,203 @@ type capture struct {
 }
 
 func (c *capture) string(inputString string) string {
-	s := c.start
-	e := c.end
+	span := c.span(inputString)
+	if span.Start < 0 {
+		return ""
+	}
+	return inputString[span.Start:span.End]
+}
 
-	if s < 0 {
-		s = 0
+// span returns the position of the capture, or (-1, -1) if the group has not ended
+func (c *capture) span(inputString string) Span {
+	if c.start < 0 || c.end < 0 {
+		return Span{Start: -1, End: -1}
 	}
 
-	if e > len(inputString) || e == -1 {
+	e := c.end
+	if e > len(inputString) {
 		e = len(inputString)
 	}
 
-	return inputString[s:e]
+	return Span{Start: c.start, End: e}
+}
+
+// editBudget keeps track of the edits spent in an approximate part of the pattern
+type editBudget struct {
+	max  int
+	used int
 }
 
 type regexCheckContext struct {
-	groups map[string]*capture
+	groups       map[string][]*capture // each group name has a stack of captures, the latest is the last
+	spanEnd      int                   // if not negative, the terminal state only accepts at this position, it's set by MatchFull
+	maxEnd       int                   // if not negative, the terminal state only accepts up to this position
+	budgets      []*editBudget         // the budgets of the approximate parts we're in, the innermost is the last
+	edits        int                   // the number of edits spent so far
+	editLimit    int                   // if not negative, the total number of edits can't exceed this
+	matched      bool                  // whether the terminal state has been reached
+	editsAtMatch int                   // the number of edits spent when the terminal state was reached
+	longest      bool                  // whether every path is explored to find the longest match
+	best         map[string][]capture  // the captures of the best match found so far in the longest mode
+	// the position where the current iteration of each quantifier loop started
+	loopPositions map[*State]int
+	ends          map[int]bool // if set, every path is explored and the ends of all the matches are collected here
+	history       bool         // whether the group events are recorded for the capture tree
+	trail         []groupEvent // the groups starting and ending on the current path, in order
+	bestTrail     []groupEvent // the trail of the best match found so far in the longest mode
+	// the ends of the spans each span operator accepts from a position, they only depend on the input
+	operatorEnds map[operatorStart][]int
+}
+
+// groupEvent is a group starting or ending on the path of the match
+type groupEvent struct {
+	number   int
+	start    bool
+	captured capture // only set for the end: the final capture of the group
+}
+
+// addEvent records the event in the trail, and returns the function that removes it
+func (ctx *regexCheckContext) addEvent(event groupEvent) func() {
+	ctx.trail = append(ctx.trail, event)
+	length := len(ctx.trail) - 1
+	return func() {
+		ctx.trail = ctx.trail[:length]
+	}
+}
+
+func newCheckContext() *regexCheckContext {
+	return &regexCheckContext{
+		groups:        map[string][]*capture{},
+		spanEnd:       -1,
+		maxEnd:        -1,
+		editLimit:     -1,
+		loopPositions: map[*State]int{},
+		operatorEnds:  map[operatorStart][]int{},
+	}
+}
+
+// topCapture returns the latest capture of the group
+func (ctx *regexCheckContext) topCapture(groupName string) (*capture, bool) {
+	captures := ctx.groups[groupName]
+	if len(captures) == 0 {
+		return nil, false
+	}
+	return captures[len(captures)-1], true
+}
+
+// matchedCapture returns the latest capture of the group that has ended,
+// a group that has started but not ended yet, e.g., while matching its own content, has not matched anything
+func (ctx *regexCheckContext) matchedCapture(groupName string) (*capture, bool) {
+	captures := ctx.groups[groupName]
+	for i := len(captures) - 1; i >= 0; i-- {
+		if captures[i].end >= 0 {
+			return captures[i], true
+		}
+	}
+	return nil, false
+}
+
+// capturesOf returns all the captures of the group that have ended, from the earliest to the latest
+func (ctx *regexCheckContext) capturesOf(groupName string) []*capture {
+	var captures []*capture
+	for _, c := range ctx.groups[groupName] {
+		if c.end >= 0 {
+			captures = append(captures, c)
+		}
+	}
+	return captures
+}
+
+func (ctx *regexCheckContext) pushCapture(groupName string, c *capture) {
+	ctx.groups[groupName] = append(ctx.groups[groupName], c)
+}
+
+func (ctx *regexCheckContext) popCapture(groupName string) (*capture, bool) {
+	c, ok := ctx.topCapture(groupName)
+	if ok {
+		ctx.groups[groupName] = ctx.groups[groupName][:len(ctx.groups[groupName])-1]
+	}
+	return c, ok
+}
+
+// recordLongest saves the current captures if they are better than the best match so far
+func (ctx *regexCheckContext) recordLongest() {
+	candidate := map[string][]capture{}
+	for groupName, captures := range ctx.groups {
+		for _, c := range captures {
+			candidate[groupName] = append(candidate[groupName], *c)
+		}
+	}
+
+	if ctx.best == nil || isPosixBetter(candidate, ctx.best) {
+		ctx.best = candidate
+		ctx.bestTrail = append([]groupEvent{}, ctx.trail...)
+		ctx.matched = true
+		ctx.editsAtMatch = ctx.edits
+	}
+}
+
+// isPosixBetter checks if the candidate match is preferred to the best one by the POSIX rules:
+// the longer match wins, and the ties are broken by the groups from left to right,
+// the group that starts earlier wins, and then the longer one
+func isPosixBetter(candidate, best map[string][]capture) bool {
+	var numbers []int
+	for groupName := range candidate {
+		if n, err := strconv.Atoi(groupName); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	for groupName := range best {
+		if n, err := strconv.Atoi(groupName); err == nil {
+			if _, ok := candidate[groupName]; !ok {
+				numbers = append(numbers, n)
+			}
+		}
+	}
+	sort.Ints(numbers)
+
+	for _, n := range numbers {
+		groupName := strconv.Itoa(n)
+		c, cOk := lastCapture(candidate[groupName])
+		b, bOk := lastCapture(best[groupName])
+		if cOk != bOk {
+			// the group that took part in the match wins
+			return cOk
+		}
+		if !cOk {
+			continue
+		}
+		if n != 0 && c.start != b.start {
+			return c.start < b.start
+		}
+		if c.end != b.end {
+			return c.end > b.end
+		}
+	}
+	return false
+}
+
+func lastCapture(captures []capture) (capture, bool) {
+	if len(captures) == 0 {
+		return capture{}, false
+	}
+	return captures[len(captures)-1], true
+}
+
+func (ctx *regexCheckContext) canEdit() bool {
+	if len(ctx.budgets) == 0 {
+		return false
+	}
+	budget := ctx.budgets[len(ctx.budgets)-1]
+	return budget.used < budget.max && (ctx.editLimit < 0 || ctx.edits < ctx.editLimit)
+}
+
+func (ctx *regexCheckContext) useEdit() {
+	ctx.budgets[len(ctx.budgets)-1].used++
+	ctx.edits++
+}
+
+func (ctx *regexCheckContext) releaseEdit() {
+	ctx.budgets[len(ctx.budgets)-1].used--
+	ctx.edits--
 }
@@ -0,0 +1,200 @@
+package rgx
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// runeRange is an inclusive range of characters,
+// the characters are bytes unless the pattern is compiled in the Unicode mode
+type runeRange struct {
+	from rune
+	to   rune
+}
+
+type characterClassPayload struct {
+	ranges  []runeRange
+	unicode bool
+}
+
+// characterClass is a transition that consumes a single character if it is in the ranges,
+// or if it is not in them when the class is negated
+type characterClass struct {
+	ranges  []runeRange
+	negated bool
+	unicode bool
+	target  *State
+}
+
+// match checks if the character at pos belongs to this class,
+// and returns the number of bytes the character takes
+func (c *characterClass) match(inputString string, pos int) (int, bool) {
+	if pos < 0 || pos >= len(inputString) {
+		return 0, false
+	}
+
+	ch, size := rune(inputString[pos]), 1
+	if c.unicode {
+		ch, size = utf8.DecodeRuneInString(inputString[pos:])
+	}
+
+	inRanges := false
+	for _, r := range c.ranges {
+		if r.from <= ch && ch <= r.to {
+			inRanges = true
+			break
+		}
+	}
+
+	return size, inRanges != c.negated
+}
+
+// the characters outside of this range don't have any other case
+const (
+	minFold = 0x0041
+	maxFold = 0x1e943
+)
+
+// foldRanges adds every case variant of the characters in the ranges.
+// only the ASCII letters have other cases unless the pattern is in the Unicode mode,
+// which uses the Unicode simple case folding, e.g., k, K and the Kelvin sign (U+212A)
+func foldRanges(ranges []runeRange, unicodeMode bool) []runeRange {
+	folded := append([]runeRange{}, ranges...)
+	for _, r := range ranges {
+		if !unicodeMode {
+			folded = appendShiftedRange(folded, r, 'a', 'z', 'A'-'a')
+			folded = appendShiftedRange(folded, r, 'A', 'Z', 'a'-'A')
+			continue
+		}
+
+		from, to := r.from, r.to
+		if from < minFold {
+			from = minFold
+		}
+		if to > maxFold {
+			to = maxFold
+		}
+		for ch := from; ch <= to; ch++ {
+			for other := unicode.SimpleFold(ch); other != ch; other = unicode.SimpleFold(other) {
+				folded = append(folded, runeRange{from: other, to: other})
+			}
+		}
+	}
+	return normalizeRanges(folded)
+}
+
+// equalFold checks if the two characters are the same when the case is ignored
+func equalFold(a, b rune, unicodeMode bool) bool {
+	if a == b {
+		return true
+	}
+	if !unicodeMode {
+		if 'A' <= a && a <= 'Z' {
+			a += 'a' - 'A'
+		}
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		return a == b
+	}
+	for other := unicode.SimpleFold(a); other != a; other = unicode.SimpleFold(other) {
+		if other == b {
+			return true
+		}
+	}
+	return false
+}
+
+// appendShiftedRange appends the part of r that is inside [lo, hi], shifted by delta
+func appendShiftedRange(ranges []runeRange, r runeRange, lo, hi, delta rune) []runeRange {
+	from, to := r.from, r.to
+	if from < lo {
+		from = lo
+	}
+	if to > hi {
+		to = hi
+	}
+	if from > to {
+		return ranges
+	}
+	return append(ranges, runeRange{from: from + delta, to: to + delta})
+}
+
+// normalizeRanges sorts the ranges and merges the ones that overlap or touch
+func normalizeRanges(ranges []runeRange) []runeRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].from < ranges[j].from
+	})
+
+	var merged []runeRange
+	for _, r := range ranges {
+		if len(merged) > 0 && r.from <= merged[len(merged)-1].to+1 {
+			if r.to > merged[len(merged)-1].to {
+				merged[len(merged)-1].to = r.to
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// posixClasses are the ranges of the [:name:] classes inside the brackets, they only cover ASCII
+var posixClasses = map[string][]runeRange{
+	"alnum":  {{'0', '9'}, {'A', 'Z'}, {'a', 'z'}},
+	"alpha":  {{'A', 'Z'}, {'a', 'z'}},
+	"blank":  {{'\t', '\t'}, {' ', ' '}},
+	"cntrl":  {{0, 0x1f}, {0x7f, 0x7f}},
+	"digit":  {{'0', '9'}},
+	"graph":  {{'!', '~'}},
+	"lower":  {{'a', 'z'}},
+	"print":  {{' ', '~'}},
+	"punct":  {{'!', '/'}, {':', '@'}, {'[', '`'}, {'{', '~'}},
+	"space":  {{'\t', '\r'}, {' ', ' '}},
+	"upper":  {{'A', 'Z'}},
+	"word":   {{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}},
+	"xdigit": {{'0', '9'}, {'A', 'F'}, {'a', 'f'}},
+}
+
+// escapeClass returns the ranges of \d, \w and \s, and whether the escape is negated, e.g., \D.
+// like in RE2, they only cover ASCII, \s has \v as well unless the syntax is RE2
+func escapeClass(letter uint8, d Dialect) ([]runeRange, bool, bool) {
+	var ranges []runeRange
+	switch letter {
+	case 'd', 'D':
+		ranges = posixClasses["digit"]
+	case 'w', 'W':
+		ranges = posixClasses["word"]
+	case 's', 'S':
+		ranges = posixClasses["space"]
+		if d == RE2 {
+			ranges = []runeRange{{'\t', '\n'}, {'\f', '\r'}, {' ', ' '}}
+		}
+	default:
+		return nil, false, false
+	}
+	return append([]runeRange{}, ranges...), 'A' <= letter && letter <= 'Z', true
+}
+
+// complementRanges returns the characters that are not in the sorted ranges,
+// the characters are bytes unless the pattern is in the Unicode mode
+func complementRanges(ranges []runeRange, unicodeMode bool) []runeRange {
+	maxChar := rune(0xff)
+	if unicodeMode {
+		maxChar = unicode.MaxRune
+	}
+
+	var complement []runeRange
+	next := rune(0)
+	for _, r := range normalizeRanges(append([]runeRange{}, ranges...)) {
+		if r.from > next {
+			complement = append(complement, runeRange{from: next, to: r.from - 1})
+		}
+		next = r.to + 1
+	}
+	if next <= maxChar {
+		complement = append(complement, runeRange{from: next, to: maxChar})
+	}
+	return complement
+}
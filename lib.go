@@ -1,74 +1,221 @@
 package rgx
 
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
 // Compile compiles the given regex string
 func Compile(regexString string) (*State, *RegexError) {
+	return CompileWithOptions(regexString, Options{})
+}
+
+// CompileExtended compiles the given regex string with the extended syntax enabled:
+// intersection (A&B), complement (~A) and the absent operator ((?~A))
+func CompileExtended(regexString string) (*State, *RegexError) {
+	return CompileWithOptions(regexString, Options{Extended: true})
+}
+
+// CompileWithOptions compiles the given regex string written in the syntax of options.Dialect,
+// the rest of the options are the flags and the limits of the compiled pattern
+func CompileWithOptions(regexString string, options Options) (*State, *RegexError) {
+	pattern := regexString
+	var positions []int
+	if options.Dialect == PosixBasic {
+		pattern, positions = translateBasic(regexString)
+	}
+
 	parseContext := parsingContext{
 		pos:            0,
 		tokens:         []regexToken{},
+		groupNames:     &[]string{""},
 		capturedGroups: map[string]bool{},
+		options:        options,
 	}
-	if err := parse(regexString, &parseContext); err != nil {
+	if err := parse(pattern, &parseContext); err != nil {
+		if positions != nil && err.Pos >= 0 && err.Pos < len(positions) {
+			// point to the original pattern, not the translated one
+			err.Pos = positions[err.Pos]
+		}
 		return nil, err
 	}
-	return toNfa(&parseContext)
+
+	nfa, err := toNfa(&parseContext)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.MaxStates > 0 {
+		if count := nfa.countStates(); count > options.MaxStates {
+			return nil, &RegexError{
+				Code:    CompilationError,
+				Message: fmt.Sprintf("The pattern needs %d states, more than the limit of %d", count, options.MaxStates),
+			}
+		}
+	}
+
+	return nfa, nil
+}
+
+// NumSubexp returns the number of the groups in the pattern
+func (s *State) NumSubexp() int {
+	return len(s.subexpNames) - 1
+}
+
+// SubexpNames returns the names of the groups by their numbers,
+// the name of the whole match (0) and of the unnamed groups is empty
+func (s *State) SubexpNames() []string {
+	return append([]string{}, s.subexpNames...)
+}
+
+// SubexpIndex returns the number of the first group with the given name, or -1 if there's none
+func (s *State) SubexpIndex(name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, subexpName := range s.subexpNames {
+		if subexpName == name {
+			return i
+		}
+	}
+	return -1
 }
 
 // Test checks if the given input string conforms to this NFA
 func (s *State) Test(inputString string) Result {
-	checkContext := &regexCheckContext{
-		groups: map[string]*capture{},
-	}
+	checkContext := newCheckContext()
+	result := s.search(inputString, 0, len(inputString), checkContext)
+
+	return s.newResult(inputString, result, checkContext)
+}
 
-	result := s.check(inputString, -1, s.startOfText, checkContext)
+// MatchFull checks if the whole input string matches this NFA.
+// the match is anchored at both ends, so the pattern doesn't need ^ and $
+func (s *State) MatchFull(inputString string) Result {
+	checkContext := newCheckContext()
+	// the terminal state only accepts at the end of the input
+	checkContext.spanEnd = len(inputString)
 
-	// prepare the result
+	result := s.matchAt(inputString, 0, checkContext)
+
+	return s.newResult(inputString, result, checkContext)
+}
+
+// MatchPrefix checks if a prefix of the input string matches this NFA,
+// i.e., there's a match that starts at the beginning of the input
+func (s *State) MatchPrefix(inputString string) Result {
+	checkContext := newCheckContext()
+
+	result := s.matchAt(inputString, 0, checkContext)
+
+	return s.newResult(inputString, result, checkContext)
+}
+
+// newResult prepares the result of a check
+func (s *State) newResult(inputString string, matches bool, ctx *regexCheckContext) Result {
 	groups := map[string]string{}
+	var submatches []string
+	var captures map[string][]string
 
-	if result {
+	if matches {
 		// extract strings from the groups
-		for groupName, captured := range checkContext.groups {
-			groups[groupName] = captured.string(inputString)
+		for groupName := range ctx.groups {
+			if captured, ok := ctx.matchedCapture(groupName); ok {
+				groups[groupName] = captured.string(inputString)
+			}
+		}
+
+		submatches = make([]string, len(s.subexpNames))
+		for i := range submatches {
+			submatches[i] = groups[strconv.Itoa(i)]
+		}
+
+		if s.history {
+			captures = map[string][]string{}
+			for groupName := range groups {
+				for _, captured := range ctx.capturesOf(groupName) {
+					captures[groupName] = append(captures[groupName], captured.string(inputString))
+				}
+			}
 		}
 	}
 
 	return Result{
-		Matches: result,
-		Groups:  groups,
+		Matches:    matches,
+		Groups:     groups,
+		Submatches: submatches,
+		Captures:   captures,
+		Edits:      ctx.editsAtMatch,
 	}
 }
 
+// FindMatches returns all the successive, non-overlapping matches in the inputString.
+// the same way as Go's regexp.FindAll does, the search continues right at the end of a match,
+// after an empty match it skips one character, and an empty match right after the previous match is ignored
 func (s *State) FindMatches(inputString string) []Result {
 	var results []Result
-	start := -1
-	for start < len(inputString) {
-		checkContext := &regexCheckContext{
-			groups: map[string]*capture{},
+	s.forEachMatch(inputString, 0, len(inputString), func(ctx *regexCheckContext) {
+		results = append(results, s.newResult(inputString, true, ctx))
+	})
+	return results
+}
+
+// forEachMatch calls the 'found' function with the context of every match FindMatches returns
+// that is inside the inputString[from:to]
+func (s *State) forEachMatch(inputString string, from, to int, found func(ctx *regexCheckContext)) {
+	cursor := matchCursor{start: from, end: to, previousEnd: -1}
+	for {
+		checkContext, ok := s.nextMatch(inputString, &cursor)
+		if !ok {
+			return
 		}
-		result := s.check(inputString, start, s.startOfText, checkContext)
-		if !result {
-			break
+		found(checkContext)
+	}
+}
+
+// matchCursor is where the search for the next match continues
+type matchCursor struct {
+	start       int // the next match can't start before this
+	end         int // the matches can't end after this
+	previousEnd int // the end of the previous match, -1 if there's none
+}
+
+// nextMatch finds the next match after the cursor and moves the cursor past it
+func (s *State) nextMatch(inputString string, cursor *matchCursor) (*regexCheckContext, bool) {
+	for cursor.start <= cursor.end {
+		checkContext := newCheckContext()
+		checkContext.maxEnd = cursor.end
+		if !s.search(inputString, cursor.start, cursor.end, checkContext) {
+			cursor.start = cursor.end + 1
+			return nil, false
 		}
-		// prepare the result
-		groups := map[string]string{}
 
-		if result {
-			// extract strings from the groups
-			for groupName, captured := range checkContext.groups {
-				groups[groupName] = captured.string(inputString)
-				if groupName == "0" {
-					start = captured.end + 1
-				}
-			}
+		matched, _ := checkContext.topCapture("0")
+		accepted := true
+		if matched.start == matched.end {
+			accepted = matched.start != cursor.previousEnd
+			cursor.start = matched.end + s.charSizeAt(inputString, matched.end)
+		} else {
+			cursor.start = matched.end
 		}
+		cursor.previousEnd = matched.end
 
-		r := Result{
-			Matches: result,
-			Groups:  groups,
+		if accepted {
+			return checkContext, true
 		}
+	}
+	return nil, false
+}
 
-		results = append(results, r)
+// charSizeAt returns the number of bytes the character at pos takes,
+// it's 1 at the end of the input so that the position moves past it
+func (s *State) charSizeAt(inputString string, pos int) int {
+	if !s.unicode || pos >= len(inputString) {
+		return 1
 	}
-	return results
+	_, size := utf8.DecodeRuneInString(inputString[pos:])
+	return size
 }
 
 // Check compiles the regexString and tests the inputString against it
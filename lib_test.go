@@ -3,6 +3,8 @@ package rgx
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -13,8 +15,10 @@ func TestCheck(t *testing.T) {
 	}{
 		// optionals
 		{"a?b?c?$", "abc", true},
-		{"a?b?c?$", "cd", false},
-		{"a?b?c?$", "cdddd", false},
+		{"a?b?c?$", "cd", true},
+		{"a?b?c?$", "cdddd", true},
+		{"$", "abc", true},
+		{"a?$", "b", true},
 		{"a?b?c?$", "c", true},
 		{"a?b?c?$", "bc", true},
 		{"a?b?c?$", "", true},
@@ -132,6 +136,24 @@ func TestCheck(t *testing.T) {
 		{`\\\^\$\.\|\?\*\+\(\)\{\}-hello`, `\^$.|?*+(){}-hello`, true},
 		{`[[\]-]+`, `]-[]-[]-[[]]--[]`, true},
 		{`[[\]-]+$`, `]-[]-[]-[[]]--[]\`, false},
+		// printable characters are literals
+		{`user_name@host`, `user_name@host`, true},
+		{`user_name@host`, `username@host`, false},
+		{`<a href="#top">'~!%:'</a>`, `<a href="#top">'~!%:'</a>`, true},
+		{`^100%$`, `100`, false},
+		{`a)]}`, `a)]}`, true},
+		// any byte value can be matched
+		{`a\x00b`, "a\x00b", true},
+		{`a\x00b`, "ab", false},
+		{`a.b`, "a\x00b", true},
+		{`a.b`, "a\x03b", true},
+		{`^\x01`, "\x01", true},
+		{`^[\x00-\x03]{4}$`, "\x00\x01\x02\x03", true},
+		{`^[\x00-\x03]{3}$`, "\x00\x01\x04", false},
+		{`\xff\xfe`, "\xff\xfe", true},
+		{`^[\x80-\xff]{2}$`, "\x80\xff", true},
+		{`h[^\x00]llo`, "h\x00llo", false},
+		{`.a`, "a", false},
 	}
 
 	for _, test := range data {
@@ -148,6 +170,650 @@ func TestCheck(t *testing.T) {
 	}
 }
 
+func TestSyntaxErrors(t *testing.T) {
+	var data = []struct {
+		regexString string
+		pos         int
+	}{
+		{"ab\x01c", 2},
+		{"a\tb", 1},
+		{"(a\x7f)", 2},
+		{"(ab", 3},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%q", test.regexString)
+		t.Run(testName, func(t *testing.T) {
+			_, err := Compile(test.regexString)
+			if err == nil {
+				t.Fatalf("expected a syntax error")
+			}
+			if err.Code != SyntaxError || err.Pos != test.pos {
+				t.Fatalf("expected a syntax error at %d, got: %s", test.pos, err.Error())
+			}
+		})
+	}
+}
+
+func TestCheckExtended(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           bool
+	}{
+		// intersection
+		{`^([a-z]+&[a-c]+)$`, "abc", true},
+		{`^([a-z]+&[a-c]+)$`, "abd", false},
+		{`^(.*a.*&.*b.*)$`, "xaxb", true},
+		{`^(.*a.*&.*b.*)$`, "xbxa", true},
+		{`^(.*a.*&.*b.*)$`, "xaxa", false},
+		// complement
+		{`^a~bc$`, "abc", false},
+		{`^a~bc$`, "ac", true},
+		{`^a~bc$`, "abbc", true},
+		{`^(~(.*cat.*)&[a-z]+)$`, "dog", true},
+		{`^(~(.*cat.*)&[a-z]+)$`, "concatenate", false},
+		// absent operator
+		{`^/\*(?~\*/)\*/$`, "/* comment */", true},
+		{`^/\*(?~\*/)\*/$`, "/* comment */ code */", false},
+		{`/\*(?~\*/)\*/`, "code /* comment */ code */", true},
+		{`^a(?~b)c$`, "aaac", true},
+		{`^a(?~b)c$`, "aabc", false},
+		// anchors inside the operands still see the whole input
+		{`x(^x.*&.*y$)`, "xxy", false},
+		{`(^x.*&.*y$)`, "xxy", true},
+		// a long unterminated comment, every span of it is checked in a single pass
+		{`/\*(?~\*/)\*/`, "/*" + strings.Repeat("x", 20000), false},
+		{`/\*(?~\*/)\*/`, "/*" + strings.Repeat("x", 20000) + "*/", true},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := CompileExtended(test.regexString)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if test.expected != pattern.Test(test.input).Matches {
+				t.Errorf("test %s failed", testName)
+			}
+		})
+	}
+}
+
+func TestExtendedOperands(t *testing.T) {
+	pattern, err := CompileWithOptions(`^(.+&~(é))$`, Options{Extended: true, Unicode: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if pattern.Test("é").Matches || !pattern.Test("éé").Matches {
+		t.Fatalf("the operands must match whole characters in the Unicode mode")
+	}
+
+	// the operands must be regular
+	for _, regexString := range []string{`(a)~(\1)`, `(a)(?~\1)`, `(?<x>a)(?(x)b|c)&b`, `a{~1}&b`} {
+		if _, err := CompileExtended(regexString); err == nil || err.Code != CompilationError {
+			t.Fatalf("expected a compilation error for %s", regexString)
+		}
+	}
+}
+
+func TestExtendedSyntaxIsOptIn(t *testing.T) {
+	if _, err := Compile(`(?~abc)`); err == nil {
+		t.Fatalf("absent operator must not be available without the extended syntax")
+	}
+
+	result, err := Check(`a&b`, "a&b")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !result.Matches {
+		t.Fatalf("& must be a literal without the extended syntax")
+	}
+}
+
+func TestCompileWithOptions(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		options            Options
+		expected           bool
+	}{
+		// dialects
+		{`(a)\1`, "aa", Options{}, true},
+		{`(?P<x>a)\k<x>`, "aa", Options{Dialect: RE2}, false},
+		{`(?P<x>a)b`, "ab", Options{Dialect: RE2}, true},
+		{`(?<x>a)\k<x>`, "aa", Options{Dialect: ECMAScript}, true},
+		{`\(a\)\1`, "aa", Options{Dialect: PosixBasic}, true},
+		{`a+`, "a+", Options{Dialect: PosixBasic}, true},
+		{`a+`, "aa", Options{Dialect: PosixBasic}, false},
+		{`a\{2\}`, "aa", Options{Dialect: PosixBasic}, true},
+		{`*a`, "*a", Options{Dialect: PosixBasic}, true},
+		{`^(a|b)+$`, "abba", Options{Dialect: PosixExtended}, true},
+		// classes
+		{`^[[:digit:]]+$`, "123", Options{Dialect: PosixExtended}, true},
+		{`^[[:digit:]]+$`, "d]", Options{Dialect: PosixExtended}, false},
+		{`^[[:alpha:][:digit:]_]+$`, "ab_1", Options{Dialect: PosixExtended}, true},
+		{`^[^[:space:]]+$`, "a b", Options{Dialect: PosixExtended}, false},
+		{`^[]a]+$`, "]a", Options{Dialect: PosixExtended}, true},
+		{`^[\n]+$`, `n\`, Options{Dialect: PosixExtended}, true},
+		{`^[[:digit:]]\{2\}$`, "12", Options{Dialect: PosixBasic}, true},
+		{`^[[:punct:]+]*$`, "+!", Options{Dialect: PosixBasic}, true},
+		{`^[[:^digit:]]+$`, "ab", Options{}, true},
+		{`^[[:word:]]+$`, "a_1", Options{Dialect: RE2}, true},
+		{`^[[:digit:]]$`, "d]", Options{Dialect: ECMAScript}, true},
+		{`^a\d$`, "a1", Options{}, true},
+		{`^a\d$`, "ad", Options{}, false},
+		{`^a\d$`, "a1", Options{Dialect: ECMAScript}, true},
+		{`^\w+\s\W$`, "ab_ !", Options{Dialect: RE2}, true},
+		{`^[\d\s]+$`, "1 2", Options{Dialect: RE2}, true},
+		{`^[\D]+$`, "a\nb", Options{}, true},
+		{`^\D+$`, "a\nb", Options{}, true},
+		{`^\S+$`, "a b", Options{}, false},
+		// flags
+		{`^hello$`, "HeLLo", Options{CaseInsensitive: true}, true},
+		{`^[a-c]+$`, "aBC", Options{CaseInsensitive: true}, true},
+		{`^a.b$`, "a\nb", Options{}, false},
+		{`^a.b$`, "a\nb", Options{DotAll: true}, true},
+		{`^a[^x]b$`, "a\nb", Options{DotAll: true}, true},
+		{`^b$`, "a\nb", Options{}, false},
+		{`^b$`, "a\nb", Options{Multiline: true}, true},
+		// unicode
+		{`^.$`, "é", Options{}, false},
+		{`^.$`, "é", Options{Unicode: true}, true},
+		{`^[à-ö]+$`, "éö", Options{Unicode: true}, true},
+		{`^[^à-ö]$`, "é", Options{Unicode: true}, false},
+		{`^é+$`, "ééé", Options{Unicode: true}, true},
+		// anchors after a repetition
+		{`x(a|b)*$`, "xabab", Options{}, true},
+		{`x(a|b)*$`, "xababc", Options{}, false},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%s-%t", test.options.Dialect, test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			compiled, err := CompileWithOptions(test.regexString, test.options)
+			if err != nil {
+				if test.expected {
+					t.Fatalf(err.Error())
+				}
+				return
+			}
+			if result := compiled.Test(test.input); test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+		})
+	}
+}
+
+func TestCompileWithOptionsErrors(t *testing.T) {
+	var data = []struct {
+		regexString string
+		options     Options
+		pos         int
+	}{
+		{`(a)\1`, Options{Dialect: RE2}, 3},
+		{`(?<x>a)`, Options{Dialect: PosixExtended}, 1},
+		{`(?e<=1)abc`, Options{Dialect: ECMAScript}, 2},
+		{`ab{~1}`, Options{Dialect: RE2}, 3},
+		{`a\{x\}`, Options{Dialect: PosixBasic}, 3},
+		{`a\d`, Options{Dialect: PosixExtended}, 1},
+		{`a\w`, Options{Dialect: PosixBasic}, 1},
+		{`a[[:digits:]]`, Options{Dialect: PosixExtended}, 2},
+		{`a[[:word:]]`, Options{Dialect: PosixExtended}, 2},
+		{`a[[.x.]]`, Options{Dialect: PosixExtended}, 2},
+		{`a[[:alpha]]`, Options{}, 2},
+		{`a{1,20}`, Options{MaxRepeat: 10}, 2},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s", test.options.Dialect, test.regexString)
+		t.Run(testName, func(t *testing.T) {
+			_, err := CompileWithOptions(test.regexString, test.options)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if err.Pos != test.pos {
+				t.Fatalf("expected the error at %d got %d", test.pos, err.Pos)
+			}
+		})
+	}
+
+	if _, err := CompileWithOptions(`a{100}`, Options{MaxStates: 50}); err == nil || err.Code != CompilationError {
+		t.Fatalf("expected the states limit to be exceeded")
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		unicode            bool
+		expected           bool
+	}{
+		{`^hello$`, "HELLO", false, true},
+		{`^(a)\1$`, "aA", false, true},
+		{`^(?<x>ab)\k<x>$`, "abAB", false, true},
+		{`^[^a]$`, "A", false, false},
+		{`^é$`, "É", false, false},
+		{`^é$`, "É", true, true},
+		{`^[à-ö]+$`, "ÀÉÖ", true, true},
+		{`^k$`, "\u212a", true, true},
+		{`^k$`, "\u212a", false, false},
+		{`^(straße)\1$`, "straßeSTRAßE", true, true},
+		{`^(s)\1$`, "sſ", true, true},
+		{`^(é)\1$`, "éÉ", true, true},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			compiled, err := CompileWithOptions(test.regexString, Options{CaseInsensitive: true, Unicode: test.unicode})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if result := compiled.Test(test.input); test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+		})
+	}
+}
+
+func TestInlineCaseInsensitiveFlag(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           bool
+	}{
+		{`(?i)^hello$`, "HeLLo", true},
+		{`^a(?i)b$`, "aB", true},
+		{`^a(?i)b$`, "AB", false},
+		{`^(a(?i)b)c$`, "aBc", true},
+		{`^(a(?i)b)c$`, "aBC", false},
+		{`(?i)^a(?-i)b$`, "Ab", true},
+		{`(?i)^a(?-i)b$`, "AB", false},
+		// only the flags, the pattern matches the empty string
+		{`(?i)`, "abc", true},
+		{`(?m)`, "", true},
+		{`(?s)`, "abc", true},
+		{`^a((?i))b$`, "ab", true},
+		{`^()$`, "", true},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			result, err := Check(test.regexString, test.input)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+		})
+	}
+
+	if _, err := Compile(`(?x)a`); err == nil {
+		t.Fatalf("unknown inline flags must be rejected")
+	}
+	if _, err := CompileWithOptions(`(?i)a`, Options{Dialect: ECMAScript}); err == nil {
+		t.Fatalf("inline flags must not be available in the ECMAScript syntax")
+	}
+}
+
+func TestMultilineAnchors(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           bool
+	}{
+		{`^[a-z]+$`, "valid", true},
+		{`^[a-z]+$`, "valid\n<script>", false},
+		{`^[a-z]+$`, "<script>\nvalid", false},
+		{`(?m)^[a-z]+$`, "valid\n<script>", true},
+		{`(?m)^[a-z]+$`, "<script>\nvalid", true},
+		{`(?m)^b(?-m)$`, "a\nb\nc", false},
+		{`(?m)^c(?-m)$`, "a\nb\nc", true},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			result, err := Check(test.regexString, test.input)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+		})
+	}
+}
+
+func TestDotAll(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           bool
+		captured           string
+	}{
+		{`^a.*b$`, "a\n\nb", false, ""},
+		{`(?s)^a.*b$`, "a\n\nb", true, "a\n\nb"},
+		{`(?s)^a[^x]b$`, "a\nb", true, "a\nb"},
+		{`(?s)^a(?-s).b$`, "a\nb", false, ""},
+		{`(?s)begin(.*)end`, "x begin\n  at main.go:10\n  at lib.go:20\nend y", true, "begin\n  at main.go:10\n  at lib.go:20\nend"},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			result, err := Check(test.regexString, test.input)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			if test.expected && result.Groups["0"] != test.captured {
+				t.Fatalf("expected %q got %q", test.captured, result.Groups["0"])
+			}
+		})
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		options            Options
+		expected           bool
+		captured           string
+	}{
+		{`(?m)^b$`, "a\r\nb\r\nc", Options{}, false, ""},
+		{`(?m)^b$`, "a\r\nb\r\nc", Options{CRLF: true}, true, "b"},
+		{`(?m)^b.$`, "a\r\nb\r\nc", Options{}, true, "b\r"},
+		{`(?m)^b.$`, "a\r\nb\r\nc", Options{CRLF: true}, false, ""},
+		{`(?m)b\r$`, "b\r\n", Options{CRLF: true}, false, ""},
+		{`(?m)^[^x]+$`, "ab\r\ncd", Options{CRLF: true}, true, "ab"},
+		{`(?m)^b$`, "a\nb\nc", Options{CRLF: true}, true, "b"},
+		{`(?m)a$`, "a\rb", Options{CRLF: true}, true, "a"},
+		{`(?m)^b$`, "a\rb", Options{CRLF: true}, true, "b"},
+		{`(?m)^$`, "\r\n", Options{CRLF: true}, true, ""},
+		{`a.b`, "a\rb", Options{CRLF: true}, false, ""},
+		// \R
+		{`^a\Rb$`, "a\r\nb", Options{}, true, "a\r\nb"},
+		{`^a\Rb$`, "a\nb", Options{}, true, "a\nb"},
+		{`^a\Rb$`, "a\u2028b", Options{}, true, "a\u2028b"},
+		{`^a\Rb$`, "a\u0085b", Options{Unicode: true}, true, "a\u0085b"},
+		{`^a\R\Rb$`, "a\r\nb", Options{}, true, "a\r\nb"},
+		{`^a\Rb$`, "a\tb", Options{}, false, ""},
+		{`^(a\R)+$`, "a\na\r\na\r", Options{}, true, "a\na\r\na\r"},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%q-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			compiled, err := CompileWithOptions(test.regexString, test.options)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			result := compiled.Test(test.input)
+			if test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			if test.expected && result.Groups["0"] != test.captured {
+				t.Fatalf("expected %q got %q", test.captured, result.Groups["0"])
+			}
+		})
+	}
+}
+
+func TestLeftmostFirstCaptures(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           map[string]string
+	}{
+		{`if|ifelse`, "ifelse", map[string]string{"0": "if"}},
+		{`(a|ab)(c|bcd)(d*)`, "abcd", map[string]string{"0": "abcd", "1": "a", "2": "bcd", "3": ""}},
+		{`(x|xy)z`, "xyz", map[string]string{"0": "xyz", "1": "xy"}},
+		{`(a+)(a+)`, "aaaa", map[string]string{"0": "aaaa", "1": "aaa", "2": "a"}},
+		{`(.*)-(.*)`, "a-b-c", map[string]string{"0": "a-b-c", "1": "a-b", "2": "c"}},
+		{`(a|b)*c`, "abac", map[string]string{"0": "abac", "1": "a"}},
+		{`a?b?`, "ab", map[string]string{"0": "ab"}},
+		{`^(a?)*b$`, "aab", map[string]string{"0": "aab", "1": ""}},
+		{`^(a*)*$`, "aa", map[string]string{"0": "aa", "1": ""}},
+		// the groups are numbered by their opening parentheses, once
+		{`^(a){3}b(c)\2$`, "aaabcc", map[string]string{"0": "aaabcc", "1": "a", "2": "c"}},
+		{`^([a-c]){2,3}$`, "abc", map[string]string{"0": "abc", "1": "c"}},
+		{`^((a)b)\2$`, "aba", map[string]string{"0": "aba", "1": "ab", "2": "a"}},
+		{`^(?<x>(a)|b)+$`, "ab", map[string]string{"0": "ab", "1": "b", "x": "b", "2": "a"}},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			result, err := Check(test.regexString, test.input)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if !result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			if len(result.Groups) != len(test.expected) {
+				t.Fatalf("expected groups %v got %v", test.expected, result.Groups)
+			}
+			for groupName, expected := range test.expected {
+				if result.Groups[groupName] != expected {
+					t.Fatalf("group %s: expected %q got %q", groupName, expected, result.Groups[groupName])
+				}
+			}
+		})
+	}
+
+	result, err := Check(`(a)|b`, "b")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := result.Groups["1"]; ok {
+		t.Fatalf("the group of the failed branch must not be captured")
+	}
+}
+
+func TestLongest(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           map[string]string
+	}{
+		{`if|ifelse`, "ifelse", map[string]string{"0": "ifelse"}},
+		{`(a|ab)(c|bcd)(d*)`, "abcd", map[string]string{"0": "abcd", "1": "ab", "2": "c", "3": "d"}},
+		{`(a*)(a*)`, "aaa", map[string]string{"0": "aaa", "1": "aaa", "2": ""}},
+		{`(a|b)*`, "abab", map[string]string{"0": "abab", "1": "b"}},
+		{`[a-z]+|[a-z]+[0-9]+`, "abc123 y", map[string]string{"0": "abc123"}},
+		{`^(a|ab)(b*)$`, "abbb", map[string]string{"0": "abbb", "1": "ab", "2": "bb"}},
+		{`(a|ab)(b*)\1`, "abba", map[string]string{"0": "abba", "1": "a", "2": "bb"}},
+		{`([a-z]+ ?)*`, strings.Repeat("ab ", 2000), map[string]string{"0": strings.Repeat("ab ", 2000), "1": "ab "}},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%.20s", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			compiled, err := CompileWithOptions(test.regexString, Options{Longest: true})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			result := compiled.Test(test.input)
+			if !result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			for groupName, expected := range test.expected {
+				if result.Groups[groupName] != expected {
+					t.Fatalf("group %s: expected %q got %q", groupName, expected, result.Groups[groupName])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckApproximate(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           bool
+		edits              int
+	}{
+		// the whole pattern
+		{`(?e<=1)^color$`, "color", true, 0},
+		{`(?e<=1)^color$`, "colr", true, 1},
+		{`(?e<=1)^color$`, "colour", true, 1},
+		{`(?e<=1)^color$`, "cilor", true, 1},
+		{`(?e<=1)^color$`, "cilr", false, 0},
+		{`(?e<=2)^color$`, "cilr", true, 2},
+		{`(?e<=2)^color$`, "cilrx", false, 0},
+		// a single group
+		{`^(colou?r){~1}s$`, "colors", true, 0},
+		{`^(colou?r){~1}s$`, "coloors", true, 1},
+		{`^(colou?r){~1}s$`, "coloorz", false, 0},
+		{`^(colou?r){~1}s$`, "cloloors", false, 0},
+		{`^(colou?r){~1}-(colou?r){~1}$`, "colr-colur", true, 2},
+		{`^a{~1}b$`, "xb", true, 1},
+		{`^a{~1}b$`, "xyb", false, 0},
+		// exact parts stay exact
+		{`^x(colou?r){~1}$`, "ycolor", false, 0},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			result, err := Check(test.regexString, test.input)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			if test.expected && test.edits != result.Edits {
+				t.Fatalf("expected %d edits got %d", test.edits, result.Edits)
+			}
+		})
+	}
+
+	// a cheaper match that starts later wins
+	var cheaper = []struct {
+		regexString, input, expected string
+		edits                        int
+	}{
+		{`(?e<=1)hello`, "xhello", "hello", 0},
+		{`(?e<=2)hello`, "say hallo", "hallo", 1},
+		{`(?e<=2)hello`, "hxllo hello", "hxllo", 1},
+		{`(?e<=2)hello`, "hxllo", "hxllo", 1},
+		{`(?e<=1)hello`, "hallo", "hallo", 1},
+	}
+
+	for _, test := range cheaper {
+		testName := fmt.Sprintf("%s-%s", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := Compile(test.regexString)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			match, ok := pattern.Find(test.input)
+			if !ok || match.Text() != test.expected || match.Edits != test.edits {
+				t.Fatalf("expected %q with %d edits got %q with %d", test.expected, test.edits, match.Text(), match.Edits)
+			}
+		})
+	}
+}
+
+func TestBalancingGroups(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           bool
+		groups             map[string]string
+	}{
+		// nesting depth
+		{`^((?<open>\()|(?<-open>\))|[^()])*(?(open)(?!))$`, "(a(b)c)", true, nil},
+		{`^((?<open>\()|(?<-open>\))|[^()])*(?(open)(?!))$`, "((a)(b))", true, nil},
+		{`^((?<open>\()|(?<-open>\))|[^()])*(?(open)(?!))$`, "((a)", false, nil},
+		{`^((?<open>\()|(?<-open>\))|[^()])*(?(open)(?!))$`, "(a))", false, nil},
+		{`^((?<open>\()|(?<-open>\))|[^()])*(?(open)(?!))$`, ")(", false, nil},
+		// capturing the balanced content
+		{`^(?<open>\()[a-z]*(?<close-open>\))$`, "(abc)", true, map[string]string{"close": "abc"}},
+		// conditionals
+		{`^(\[)?a(?(1)]|;)$`, "[a]", true, nil},
+		{`^(\[)?a(?(1)]|;)$`, "a;", true, nil},
+		{`^(\[)?a(?(1)]|;)$`, "[a;", false, nil},
+		{`^(\[)?a(?(1)]|;)$`, "a]", false, nil},
+		// the number and the name of the popped group agree
+		{`^(?<open>a)(?<-open>b)(?(1)x|y)$`, "aby", true, nil},
+		{`^(?<open>a)(?<-open>b)(?(open)x|y)$`, "aby", true, nil},
+		{`^(?<open>a)(?<-1>b)(?(open)x|y)$`, "aby", true, nil},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s-%t", test.regexString, test.input, test.expected)
+		t.Run(testName, func(t *testing.T) {
+			result, err := Check(test.regexString, test.input)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if test.expected != result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			for k, v := range test.groups {
+				if result.Groups[k] != v {
+					t.Fatalf("expected '%s' got: '%s'", v, result.Groups[k])
+				}
+			}
+		})
+	}
+
+	result, err := Check(`(?<open>a)(?<-open>b)`, "ab")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := result.Groups["1"]; ok || result.Submatches[1] != "" {
+		t.Fatalf("the popped capture must be gone from the group number too, got %q", result.Groups["1"])
+	}
+	if _, ok := result.Group("open"); ok {
+		t.Fatalf("the popped capture must be gone from the group name")
+	}
+}
+
+func TestMatchFullAndPrefix(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		full, prefix       bool
+		captured           string
+	}{
+		{`[a-z]+`, "abc", true, true, "abc"},
+		{`[a-z]+`, "abc1", false, true, "abc"},
+		{`[a-z]+`, "1abc", false, false, ""},
+		{`[a-z]+`, "ab\ncd", false, true, "ab"},
+		{`(?m)[a-z]+$`, "ab\ncd", false, true, "ab"},
+		{`a|ab`, "ab", true, true, "a"},
+		{`x*`, "", true, true, ""},
+		{`(?e<=1)color`, "colr", true, true, "colr"},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%q", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := Compile(test.regexString)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			full := pattern.MatchFull(test.input)
+			if full.Matches != test.full {
+				t.Fatalf("MatchFull: expected %t got %t", test.full, full.Matches)
+			}
+			if full.Matches && full.Groups["0"] != test.input {
+				t.Fatalf("MatchFull: expected %q got %q", test.input, full.Groups["0"])
+			}
+
+			prefix := pattern.MatchPrefix(test.input)
+			if prefix.Matches != test.prefix {
+				t.Fatalf("MatchPrefix: expected %t got %t", test.prefix, prefix.Matches)
+			}
+			if prefix.Matches && prefix.Groups["0"] != test.captured {
+				t.Fatalf("MatchPrefix: expected %q got %q", test.captured, prefix.Groups["0"])
+			}
+		})
+	}
+}
+
 func TestFindMatches(t *testing.T) {
 	var data = []struct {
 		regexString, input string
@@ -158,10 +824,22 @@ func TestFindMatches(t *testing.T) {
 			{"0": "239-987-63-21"},
 		}},
 		// multiline extracts
-		{`[0-9]{3}-[0-9]{3}-[0-9]{2}-[0-9]{2}$`, "hi 123-678-99-32\n is my number, so is 239-987-63-21", []map[string]string{
+		{`(?m)[0-9]{3}-[0-9]{3}-[0-9]{2}-[0-9]{2}$`, "hi 123-678-99-32\n is my number, so is 239-987-63-21", []map[string]string{
 			{"0": "123-678-99-32"},
 			{"0": "239-987-63-21"},
 		}},
+		// without the multiline mode, $ is only the end of the input
+		{`[0-9]{3}-[0-9]{3}-[0-9]{2}-[0-9]{2}$`, "hi 123-678-99-32\n is my number, so is 239-987-63-21", []map[string]string{
+			{"0": "239-987-63-21"},
+		}},
+		// adjacent and empty matches
+		{`a`, "aaa", []map[string]string{{"0": "a"}, {"0": "a"}, {"0": "a"}}},
+		{`a*`, "baaac", []map[string]string{{"0": ""}, {"0": "aaa"}, {"0": ""}}},
+		{`a|`, "abaa", []map[string]string{{"0": "a"}, {"0": "a"}, {"0": "a"}}},
+		{`x*`, "", []map[string]string{{"0": ""}}},
+		// an exact match later in the input doesn't hide the approximate ones before it
+		{`(?e<=1)hello`, "helo world hello", []map[string]string{{"0": "helo"}, {"0": "hello"}}},
+		{`(?e<=1)hello`, "xhello hallo", []map[string]string{{"0": "hello"}, {"0": "hallo"}}},
 	}
 
 	for _, test := range data {
@@ -186,6 +864,507 @@ func TestFindMatches(t *testing.T) {
 	}
 }
 
+func TestFindPositions(t *testing.T) {
+	pattern, err := Compile(`(?<user>[a-z]+)@(?<host>[a-z]+)`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	input := "mail bob@home or al@work"
+	match, ok := pattern.Find(input)
+	if !ok {
+		t.Fatalf("expected a match")
+	}
+	if match.Span != (Span{Start: 5, End: 13}) || match.Text() != "bob@home" {
+		t.Fatalf("unexpected match %+v", match.Span)
+	}
+	if match.Groups["user"] != (Span{Start: 5, End: 8}) || match.Groups["2"] != (Span{Start: 9, End: 13}) {
+		t.Fatalf("unexpected groups %+v", match.Groups)
+	}
+	if host, ok := match.GroupText("host"); !ok || host != "home" {
+		t.Fatalf("expected 'home' got '%s'", host)
+	}
+	if _, ok := match.GroupText("missing"); ok {
+		t.Fatalf("missing group must not have a text")
+	}
+
+	if index := pattern.FindIndex(input); !reflect.DeepEqual(index, []int{5, 13}) {
+		t.Fatalf("unexpected index %v", index)
+	}
+	if index := pattern.FindIndex("nothing"); index != nil {
+		t.Fatalf("expected no index got %v", index)
+	}
+
+	expected := [][]int{{5, 13}, {17, 24}}
+	if indexes := pattern.FindAllIndex(input); !reflect.DeepEqual(indexes, expected) {
+		t.Fatalf("expected %v got %v", expected, indexes)
+	}
+
+	matches := pattern.FindAll(input)
+	if len(matches) != 2 || matches[1].Text() != "al@work" {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+}
+
+func TestSubexps(t *testing.T) {
+	pattern, err := Compile(`(?<year>[0-9]{4})-([0-9]{2})-(?<day>[0-9]{2})(x)?`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if pattern.NumSubexp() != 4 {
+		t.Fatalf("expected 4 groups got %d", pattern.NumSubexp())
+	}
+	if names := pattern.SubexpNames(); !reflect.DeepEqual(names, []string{"", "year", "", "day", ""}) {
+		t.Fatalf("unexpected names %q", names)
+	}
+	if pattern.SubexpIndex("day") != 3 || pattern.SubexpIndex("month") != -1 || pattern.SubexpIndex("") != -1 {
+		t.Fatalf("unexpected indexes")
+	}
+
+	result := pattern.Test("on 2024-05-17")
+	if !reflect.DeepEqual(result.Submatches, []string{"2024-05-17", "2024", "05", "17", ""}) {
+		t.Fatalf("unexpected submatches %q", result.Submatches)
+	}
+
+	match, _ := pattern.Find("on 2024-05-17")
+	if match.Submatches[2] != (Span{Start: 8, End: 10}) {
+		t.Fatalf("unexpected submatches %v", match.Submatches)
+	}
+}
+
+func TestUnmatchedGroups(t *testing.T) {
+	pattern, err := Compile(`(a)|(b*)c`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	result := pattern.Test("c")
+	if text, ok := result.Group("1"); ok || text != "" {
+		t.Fatalf("group 1 must not take part in the match")
+	}
+	if text, ok := result.Group("2"); !ok || text != "" {
+		t.Fatalf("group 2 must match an empty string")
+	}
+
+	match, _ := pattern.Find("c")
+	if span, ok := match.Submatch(1); ok || span != (Span{Start: -1, End: -1}) {
+		t.Fatalf("unexpected span of group 1 %v", span)
+	}
+	if span, ok := match.Submatch(2); !ok || span != (Span{Start: 0, End: 0}) {
+		t.Fatalf("unexpected span of group 2 %v", span)
+	}
+	if match.Submatches[1] != (Span{Start: -1, End: -1}) {
+		t.Fatalf("unexpected submatches %v", match.Submatches)
+	}
+
+	// a group can't refer to itself before it ends
+	if result, _ := Check(`^(a\1)$`, "a"); result.Matches {
+		t.Fatalf("backreference to an unfinished group must not match")
+	}
+}
+
+func TestCaptureHistory(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           map[string][]string
+	}{
+		{`^(?<item>[0-9]+,)*([0-9]+)$`, "1,22,333", map[string][]string{"1": {"1,", "22,"}, "item": {"1,", "22,"}, "2": {"333"}}},
+		{`(a|b){3}`, "xaba", map[string][]string{"1": {"a", "b", "a"}}},
+		{`^((a)|b)+$`, "aba", map[string][]string{"1": {"a", "b", "a"}, "2": {"a", "a"}}},
+		{`^(x|y)*$`, "", map[string][]string{}},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := CompileWithOptions(test.regexString, Options{CaptureHistory: true})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			result := pattern.Test(test.input)
+			if !result.Matches {
+				t.Fatalf("test %s failed", testName)
+			}
+			delete(result.Captures, "0")
+			if !reflect.DeepEqual(result.Captures, test.expected) {
+				t.Fatalf("expected %q got %q", test.expected, result.Captures)
+			}
+
+			match, _ := pattern.Find(test.input)
+			for groupName, expected := range test.expected {
+				if len(match.Captures[groupName]) != len(expected) {
+					t.Fatalf("expected %d spans for %s got %v", len(expected), groupName, match.Captures[groupName])
+				}
+			}
+		})
+	}
+
+	if result, _ := Check(`(a)*`, "aa"); result.Captures != nil {
+		t.Fatalf("capture history must be opt-in")
+	}
+}
+
+func TestCaptureTree(t *testing.T) {
+	pattern, err := CompileWithOptions(`^(?<record>(?<name>[a-z]+):((?<value>[0-9]+),?)*;)+$`, Options{CaptureHistory: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	match, ok := pattern.Find("a:1,2;bc:;d:3;")
+	if !ok {
+		t.Fatalf("expected a match")
+	}
+
+	// prints the tree as group(text) with the children in brackets
+	var format func(node *CaptureNode) string
+	format = func(node *CaptureNode) string {
+		label := node.Name
+		if label == "" {
+			label = fmt.Sprintf("%d", node.Group)
+		}
+		text := fmt.Sprintf("%s(%s)", label, node.Text())
+		if len(node.Children) > 0 {
+			var children []string
+			for _, child := range node.Children {
+				children = append(children, format(child))
+			}
+			text += fmt.Sprintf("%v", children)
+		}
+		return text
+	}
+
+	expected := "0(a:1,2;bc:;d:3;)[" +
+		"record(a:1,2;)[name(a) 3(1,)[value(1)] 3(2)[value(2)]] " +
+		"record(bc:;)[name(bc)] " +
+		"record(d:3;)[name(d) 3(3)[value(3)]]]"
+	if actual := format(match.Tree); actual != expected {
+		t.Fatalf("expected %s got %s", expected, actual)
+	}
+
+	pattern, err = CompileWithOptions(`x*(?<word>([a-z])+)`, Options{CaptureHistory: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	match, _ = pattern.Find("xxab")
+	expected = "0(xxab)[word(ab)[2(a) 2(b)]]"
+	if actual := format(match.Tree); actual != expected {
+		t.Fatalf("expected %s got %s", expected, actual)
+	}
+}
+
+func TestFindAllOverlapping(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           []Span
+	}{
+		{`aa`, "aaaa", []Span{{0, 2}, {1, 3}, {2, 4}}},
+		{`ATA|TAT`, "GATATAT", []Span{{1, 4}, {2, 5}, {3, 6}, {4, 7}}},
+		{`^a`, "aa", []Span{{0, 1}}},
+		{`a+`, "aab", []Span{{0, 2}, {1, 2}}},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := Compile(test.regexString)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			var spans []Span
+			for _, match := range pattern.FindAllOverlapping(test.input) {
+				spans = append(spans, match.Span)
+			}
+			if !reflect.DeepEqual(spans, test.expected) {
+				t.Fatalf("expected %v got %v", test.expected, spans)
+			}
+		})
+	}
+
+	pattern, err := Compile(`a+`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []Span{{0, 1}, {0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 3}}
+	if spans := pattern.FindAllOverlappingSpans("aaab"); !reflect.DeepEqual(spans, expected) {
+		t.Fatalf("expected %v got %v", expected, spans)
+	}
+
+	// the matches don't start in the middle of a character
+	pattern, err = CompileWithOptions(`.`, Options{Unicode: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	matches := pattern.FindAllOverlapping("éa")
+	if len(matches) != 2 || matches[0].Text() != "é" || matches[1].Span != (Span{Start: 2, End: 3}) {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	expected = []Span{{0, 2}, {2, 3}}
+	if spans := pattern.FindAllOverlappingSpans("éa"); !reflect.DeepEqual(spans, expected) {
+		t.Fatalf("expected %v got %v", expected, spans)
+	}
+
+	// the paths that reach the same spans are not explored one by one
+	pattern, err = Compile(`(a|aa)*b`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if spans := pattern.FindAllOverlappingSpans(strings.Repeat("a", 200)); len(spans) != 0 {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+
+	// the patterns that can't be run as automata still give the spans of every path
+	pattern, err = Compile(`(a+)\1`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected = []Span{{0, 2}, {0, 4}, {1, 3}, {2, 4}}
+	if spans := pattern.FindAllOverlappingSpans("aaaa"); !reflect.DeepEqual(spans, expected) {
+		t.Fatalf("expected %v got %v", expected, spans)
+	}
+}
+
+func TestFindReverse(t *testing.T) {
+	var data = []struct {
+		regexString, input string
+		expected           []string
+	}{
+		{`[0-9]+`, "1 22 333", []string{"333", "22", "1"}},
+		{`[0-9]`, "1 22", []string{"2", "2", "1"}},
+		{`a*`, "baaa", []string{"aaa", ""}},
+		{`ERROR: [a-z]+`, "ERROR: disk\nINFO: ok\nERROR: network\nINFO: ok", []string{"ERROR: network", "ERROR: disk"}},
+		{`^[a-z]+`, "abc def", []string{"abc"}},
+		{`aa`, "aaa", []string{"aa"}},
+		{`aa`, "aaaa", []string{"aa", "aa"}},
+		{`aa`, "aaaaa b", []string{"aa", "aa"}},
+		{`(ab)*`, "aab", []string{"ab", ""}},
+		{`ab+|b`, "abb", []string{"abb"}},
+		{`b|ab+`, "abb b", []string{"b", "abb"}},
+		{`(?m)^a|b$`, "ab\nab", []string{"b", "a", "b", "a"}},
+		{`(a|b)\1`, "aabbab", []string{"bb", "aa"}},
+		{`x[a-z]*|a`, "aaxaa", []string{"xaa", "a", "a"}},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%s", test.regexString, test.input)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := Compile(test.regexString)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			var texts []string
+			var spans []Span
+			pattern.FindEachReverse(test.input, func(match Match) bool {
+				texts = append(texts, match.Text())
+				spans = append(spans, match.Span)
+				return true
+			})
+			if !reflect.DeepEqual(texts, test.expected) {
+				t.Fatalf("expected %q got %q", test.expected, texts)
+			}
+
+			all := pattern.FindAll(test.input)
+			for i, match := range all {
+				if spans[len(spans)-1-i] != match.Span {
+					t.Fatalf("expected the matches of FindAll in the reverse order, got %v", spans)
+				}
+			}
+			last, ok := pattern.FindLast(test.input)
+			if !ok || last.Span != all[len(all)-1].Span {
+				t.Fatalf("expected the last match %v got %v", all[len(all)-1].Span, last.Span)
+			}
+		})
+	}
+
+	pattern, err := CompileWithOptions(`é+`, Options{Unicode: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if last, ok := pattern.FindLast("éé xéé"); !ok || last.Span != (Span{Start: 6, End: 10}) {
+		t.Fatalf("unexpected last match %v", last.Span)
+	}
+
+	// a long run is walked back once, not from every position in it
+	pattern, err = Compile(`[a-z]+`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	input := strings.Repeat("a", 100000)
+	if last, ok := pattern.FindLast(input); !ok || last.Span != (Span{Start: 0, End: len(input)}) {
+		t.Fatalf("unexpected last match %v", last.Span)
+	}
+}
+
+func TestFindInWindow(t *testing.T) {
+	input := "abc def ghi"
+
+	var data = []struct {
+		regexString string
+		start, end  int
+		expected    []string
+	}{
+		{`^[a-z]+`, 4, 11, nil},
+		{`[a-z]+$`, 0, 7, nil},
+		{`[a-z]+$`, 4, 11, []string{"ghi"}},
+		{`[a-z]+`, 5, 11, []string{"ef", "ghi"}},
+		{`[a-z]+`, 0, 6, []string{"abc", "de"}},
+		{`[a-z]+`, -5, 50, []string{"abc", "def", "ghi"}},
+	}
+
+	for _, test := range data {
+		testName := fmt.Sprintf("%s-%d-%d", test.regexString, test.start, test.end)
+		t.Run(testName, func(t *testing.T) {
+			pattern, err := Compile(test.regexString)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			var texts []string
+			for _, match := range pattern.FindAllIn(input, test.start, test.end) {
+				texts = append(texts, match.Text())
+			}
+			if !reflect.DeepEqual(texts, test.expected) {
+				t.Fatalf("expected %q got %q", test.expected, texts)
+			}
+
+			match, ok := pattern.FindIn(input, test.start, test.end)
+			if ok != (len(test.expected) > 0) || (ok && match.Text() != test.expected[0]) {
+				t.Fatalf("unexpected first match %q", match.Text())
+			}
+		})
+	}
+
+	pattern, err := Compile(`[a-z]+`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if match, ok := pattern.MatchAt(input, 4); !ok || match.Text() != "def" {
+		t.Fatalf("expected 'def' got %q", match.Text())
+	}
+	if _, ok := pattern.MatchAt(input, 3); ok {
+		t.Fatalf("expected no match at 3")
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	pattern, err := Compile(`[0-9]+`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	m := pattern.Matcher("a1 b22 c333")
+	var texts []string
+	for m.Next() {
+		texts = append(texts, m.Match().Text())
+	}
+	if !reflect.DeepEqual(texts, []string{"1", "22", "333"}) {
+		t.Fatalf("unexpected matches %q", texts)
+	}
+	if m.Next() {
+		t.Fatalf("expected no more matches")
+	}
+
+	m.Reset("x4")
+	if !m.Next() || m.Match().Text() != "4" || m.Match().Span != (Span{Start: 1, End: 2}) {
+		t.Fatalf("unexpected match after reset %q", m.Match().Text())
+	}
+
+	if matches := pattern.FindN("1 2 3 4", 2); len(matches) != 2 || matches[1].Text() != "2" {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	if matches := pattern.FindN("1 2 3 4", -1); len(matches) != 4 {
+		t.Fatalf("expected all the matches got %d", len(matches))
+	}
+	if matches := pattern.FindN("1 2 3 4", 0); len(matches) != 0 {
+		t.Fatalf("expected no matches got %d", len(matches))
+	}
+}
+
+func TestFindLines(t *testing.T) {
+	pattern, err := Compile(`b+`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	input := "one\ntwo bb\r\nthree\nfour\nb five"
+	matches := pattern.FindLines(input, 1, 2)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches got %d", len(matches))
+	}
+
+	tests := []struct {
+		start, end Position
+		line       string
+		before     []string
+		after      []string
+	}{
+		{Position{Offset: 8, Line: 2, Column: 5}, Position{Offset: 10, Line: 2, Column: 7}, "two bb", []string{"one"}, []string{"three", "four"}},
+		{Position{Offset: 23, Line: 5, Column: 1}, Position{Offset: 24, Line: 5, Column: 2}, "b five", []string{"four"}, nil},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("match %d", i), func(t *testing.T) {
+			match := matches[i]
+			if match.Start != test.start || match.End != test.end {
+				t.Fatalf("expected %v-%v got %v-%v", test.start, test.end, match.Start, match.End)
+			}
+			if match.Line != test.line {
+				t.Fatalf("expected line %q got %q", test.line, match.Line)
+			}
+			if !reflect.DeepEqual(match.Before, test.before) || !reflect.DeepEqual(match.After, test.after) {
+				t.Fatalf("unexpected context %q %q", match.Before, match.After)
+			}
+		})
+	}
+
+	trailing, err := Compile(`o`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if matches := trailing.FindLines("foo\nbar\r\nbaz\no\n", 0, 1); len(matches) != 3 || matches[2].After != nil {
+		t.Fatalf("expected no line after the trailing newline got %q", matches[len(matches)-1].After)
+	}
+	if matches := trailing.FindLines("foo\nbar\r\nbaz\n", 0, 1); !reflect.DeepEqual(matches[0].After, []string{"bar"}) {
+		t.Fatalf("unexpected context %q", matches[0].After)
+	}
+
+	// the match ends with the newline, the context starts with the line right after it
+	withNewline, err := Compile(`b+\n`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if matches := withNewline.FindLines("l1\nbb\nl3\nl4\n", 0, 1); len(matches) != 1 || !reflect.DeepEqual(matches[0].After, []string{"l3"}) {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+
+	unicodePattern, err := CompileWithOptions(`x`, Options{Unicode: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if matches := unicodePattern.FindLines("a\nçéx", 0, 0); len(matches) != 1 || matches[0].Start.Column != 3 || matches[0].Before != nil {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+}
+
+func TestFindMatchesUnicode(t *testing.T) {
+	unicodePattern, err := CompileWithOptions(`x*`, Options{Unicode: true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// an empty match moves the search by a whole character
+	if results := unicodePattern.FindMatches("é"); len(results) != 2 {
+		t.Fatalf("expected 2 matches got %d", len(results))
+	}
+
+	bytePattern, err := Compile(`x*`)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if results := bytePattern.FindMatches("é"); len(results) != 3 {
+		t.Fatalf("expected 3 matches got %d", len(results))
+	}
+}
+
 func TestFindMatchesInTextFile(t *testing.T) {
 	bytes, err := os.ReadFile("lib_testdata")
 	if err != nil {
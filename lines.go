@@ -0,0 +1,159 @@
+package rgx
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Position is a byte offset in the input together with its line and column, both start from 1.
+// the column counts the characters, which are bytes unless the pattern is in the Unicode mode
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// LineMatch is a match together with its position in the lines of the input
+type LineMatch struct {
+	Match
+	Start  Position // the position of the first character of the match
+	End    Position // the position after the last character of the match
+	Line   string   // the line the match starts in, without the line terminator
+	Before []string // the lines before the line of the match, the closest is the last
+	After  []string // the lines after the line the match ends in, the closest is the first
+}
+
+// FindLines returns the same matches as FindAll with their lines and columns,
+// and up to 'before' and 'after' lines of context around each of them.
+// the lines are tracked while going through the matches, so the input is scanned only once
+func (s *State) FindLines(inputString string, before, after int) []LineMatch {
+	tracker := newLineTracker(inputString, s.unicode, before)
+
+	var matches []LineMatch
+	m := s.Matcher(inputString)
+	for m.Next() {
+		match := m.Match()
+
+		tracker.advanceTo(match.Span.Start)
+		lineMatch := LineMatch{
+			Match:  match,
+			Start:  tracker.position(match.Span.Start),
+			Line:   tracker.lineText(tracker.currentLineStart()),
+			Before: tracker.previousLines(),
+		}
+
+		tracker.advanceTo(match.Span.End)
+		lineMatch.End = tracker.position(match.Span.End)
+
+		// the context starts after the line that holds the last character of the match,
+		// which is the line before the tracker's one if the match ends with \n
+		nextLineStart := tracker.nextLineStart()
+		if match.Span.End > match.Span.Start && inputString[match.Span.End-1] == '\n' {
+			nextLineStart = match.Span.End
+		}
+		lineMatch.After = tracker.linesFrom(nextLineStart, after)
+
+		matches = append(matches, lineMatch)
+	}
+	return matches
+}
+
+// lineTracker keeps the line of a position that only moves forward
+type lineTracker struct {
+	input      string
+	unicode    bool
+	offset     int   // the position the tracker has reached
+	line       int   // the line of the offset
+	lineStarts []int // the starts of the recent lines, the last one is the line of the offset
+	keep       int   // the number of the previous lines to remember
+}
+
+func newLineTracker(inputString string, unicode bool, keep int) *lineTracker {
+	if keep < 0 {
+		keep = 0
+	}
+	return &lineTracker{
+		input:      inputString,
+		unicode:    unicode,
+		line:       1,
+		lineStarts: []int{0},
+		keep:       keep,
+	}
+}
+
+// advanceTo moves the tracker forward to the offset
+func (t *lineTracker) advanceTo(offset int) {
+	for ; t.offset < offset && t.offset < len(t.input); t.offset++ {
+		if t.input[t.offset] != '\n' {
+			continue
+		}
+		t.line++
+		t.lineStarts = append(t.lineStarts, t.offset+1)
+		if len(t.lineStarts) > t.keep+1 {
+			t.lineStarts = append(t.lineStarts[:0], t.lineStarts[1:]...)
+		}
+	}
+}
+
+func (t *lineTracker) currentLineStart() int {
+	return t.lineStarts[len(t.lineStarts)-1]
+}
+
+// position returns the position of the offset the tracker has reached
+func (t *lineTracker) position(offset int) Position {
+	column := offset - t.currentLineStart() + 1
+	if t.unicode {
+		column = utf8.RuneCountInString(t.input[t.currentLineStart():offset]) + 1
+	}
+	return Position{
+		Offset: offset,
+		Line:   t.line,
+		Column: column,
+	}
+}
+
+// lineText returns the line that starts at the given position, without \n or \r\n
+func (t *lineTracker) lineText(lineStart int) string {
+	line := t.input[lineStart:]
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	return strings.TrimSuffix(line, "\r")
+}
+
+// previousLines returns the remembered lines before the current one
+func (t *lineTracker) previousLines() []string {
+	var lines []string
+	for _, lineStart := range t.lineStarts[:len(t.lineStarts)-1] {
+		lines = append(lines, t.lineText(lineStart))
+	}
+	return lines
+}
+
+// nextLineStart returns the start of the line after the current one, or -1 if it's the last line
+func (t *lineTracker) nextLineStart() int {
+	end := strings.IndexByte(t.input[t.currentLineStart():], '\n')
+	if end < 0 {
+		return -1
+	}
+	return t.currentLineStart() + end + 1
+}
+
+// linesFrom returns up to count lines, the first one starts at lineStart, without moving the tracker
+func (t *lineTracker) linesFrom(lineStart, count int) []string {
+	var lines []string
+	for lineStart >= 0 && len(lines) < count {
+		if lineStart == len(t.input) {
+			// the input ends with a line terminator, there's no line after it
+			break
+		}
+		lines = append(lines, t.lineText(lineStart))
+
+		end := strings.IndexByte(t.input[lineStart:], '\n')
+		if end < 0 {
+			break
+		}
+		lineStart += end + 1
+	}
+	return lines
+}
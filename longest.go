@@ -0,0 +1,192 @@
+package rgx
+
+// posixThread is a path of the NFA in the longest mode, with the captures it has made so far
+type posixThread struct {
+	groups map[string][]capture
+	trail  []groupEvent
+}
+
+func (t *posixThread) clone() *posixThread {
+	groups := make(map[string][]capture, len(t.groups))
+	for groupName, captures := range t.groups {
+		groups[groupName] = append([]capture{}, captures...)
+	}
+	return &posixThread{
+		groups: groups,
+		trail:  append([]groupEvent{}, t.trail...),
+	}
+}
+
+// threadList keeps the best thread that has reached each state at a position, in the order they arrived
+type threadList struct {
+	order   []*State
+	threads map[*State]*posixThread
+}
+
+func newThreadList() *threadList {
+	return &threadList{threads: map[*State]*posixThread{}}
+}
+
+// add keeps the thread in the state unless a thread that is at least as good is already there
+func (l *threadList) add(state *State, thread *posixThread) bool {
+	existing, ok := l.threads[state]
+	if ok && !isPosixBetter(thread.groups, existing.groups) {
+		return false
+	}
+	if !ok {
+		l.order = append(l.order, state)
+	}
+	l.threads[state] = thread
+	return true
+}
+
+// posixRun runs all the paths of the NFA at the same time, like a Pike VM.
+// the threads that reach the same state at the same position have the same future,
+// so only the one with the better groups by the POSIX rules is kept
+type posixRun struct {
+	input   string
+	history bool
+	ctx     *regexCheckContext
+	pending map[int]*threadList // the threads each position continues from
+	best    *posixThread        // the best match found so far
+	bestEnd int
+}
+
+// longestMatch finds the longest match that starts at pos, with the groups chosen by the POSIX rules,
+// and saves it in ctx.best and ctx.bestTrail. it's only used for the patterns without backreferences,
+// conditionals, balancing groups and approximate parts, every path is explored for the others
+func (s *State) longestMatch(inputString string, pos int, ctx *regexCheckContext) {
+	run := &posixRun{
+		input:   inputString,
+		history: s.history,
+		ctx:     ctx,
+		pending: map[int]*threadList{},
+		bestEnd: -1,
+	}
+	run.schedule(pos, s, &posixThread{groups: map[string][]capture{}})
+
+	for p := pos; p <= len(inputString) && len(run.pending) > 0; p++ {
+		threads, ok := run.pending[p]
+		if !ok {
+			continue
+		}
+		delete(run.pending, p)
+
+		here := newThreadList()
+		for _, state := range threads.order {
+			run.visit(state, p, threads.threads[state], here)
+		}
+	}
+
+	if run.best != nil {
+		ctx.best = run.best.groups
+		ctx.bestTrail = run.best.trail
+		ctx.matched = true
+		ctx.editsAtMatch = 0
+	}
+}
+
+// schedule continues the thread from the state at a later position
+func (r *posixRun) schedule(pos int, state *State, thread *posixThread) {
+	if r.pending[pos] == nil {
+		r.pending[pos] = newThreadList()
+	}
+	r.pending[pos].add(state, thread)
+}
+
+// visit follows the transitions of the state at pos that don't consume anything,
+// and schedules the ones that do for the later positions
+func (r *posixRun) visit(s *State, pos int, thread *posixThread, here *threadList) {
+	if len(s.groups) > 0 {
+		thread = thread.clone()
+		r.updateGroups(s, pos, thread)
+	}
+	if !here.add(s, thread) {
+		return
+	}
+
+	if s.endOfText && pos != len(r.input) && !(s.multiline && s.isLineEnd(r.input, pos)) {
+		return
+	}
+	if s.startOfText && pos != 0 && !(s.multiline && s.isLineStart(r.input, pos)) {
+		return
+	}
+
+	if s.terminal {
+		if (r.ctx.spanEnd >= 0 && pos != r.ctx.spanEnd) || (r.ctx.maxEnd >= 0 && pos > r.ctx.maxEnd) {
+			return
+		}
+		// the positions only grow, so a later match is a longer one
+		if r.best == nil || pos > r.bestEnd || isPosixBetter(thread.groups, r.best.groups) {
+			r.best, r.bestEnd = thread, pos
+		}
+		return
+	}
+
+	if s.operator != nil {
+		for _, end := range s.operator.ends(r.input, pos, r.ctx) {
+			if end == pos {
+				r.visit(s.operator.target, pos, thread, here)
+			} else {
+				r.schedule(end, s.operator.target, thread)
+			}
+		}
+	}
+
+	if s.loop != nil {
+		r.visit(s.loop, pos, thread, here)
+	}
+
+	if pos < len(r.input) {
+		if nextState := s.nextStateWith(symbol(r.input[pos])); nextState != nil {
+			r.schedule(pos+1, nextState, thread)
+		} else if s.class != nil {
+			if size, ok := s.class.match(r.input, pos); ok {
+				r.schedule(pos+size, s.class.target, thread)
+			}
+		}
+	}
+
+	for _, state := range s.transitions[epsilonChar] {
+		r.visit(state, pos, thread, here)
+	}
+}
+
+// updateGroups applies the group boundaries of the state to the captures of the thread,
+// the same way as State.updateGroups does for the backtracking search
+func (r *posixRun) updateGroups(s *State, pos int, thread *posixThread) {
+	for _, capturedGroup := range s.groups {
+		if capturedGroup.start {
+			for _, groupName := range capturedGroup.names {
+				captures := thread.groups[groupName]
+				if !r.history && len(captures) > 1 {
+					// only the latest capture that has ended is needed besides the new one
+					captures = captures[len(captures)-1:]
+				}
+				thread.groups[groupName] = append(captures, capture{start: pos, end: -1})
+			}
+			if r.history && len(capturedGroup.names) > 0 {
+				thread.trail = append(thread.trail, groupEvent{
+					number: capturedGroup.number,
+					start:  true,
+				})
+			}
+		}
+
+		if capturedGroup.end {
+			for _, groupName := range capturedGroup.names {
+				captures := thread.groups[groupName]
+				if len(captures) > 0 {
+					captures[len(captures)-1].end = pos
+				}
+			}
+			if r.history && len(capturedGroup.names) > 0 {
+				captures := thread.groups[capturedGroup.names[0]]
+				thread.trail = append(thread.trail, groupEvent{
+					number:   capturedGroup.number,
+					captured: captures[len(captures)-1],
+				})
+			}
+		}
+	}
+}
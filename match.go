@@ -0,0 +1,383 @@
+package rgx
+
+import (
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Span is the position of a match or a group in the input,
+// Start is the byte offset of the first character and End is the byte offset after the last one
+type Span struct {
+	Start int
+	End   int
+}
+
+// Match is a match with the positions of the whole match and of every group
+type Match struct {
+	input      string
+	Span       Span            // the whole match
+	Groups     map[string]Span // the captured groups by their numbers and names, "0" is the whole match
+	Submatches []Span          // the groups by their numbers, 0 is the whole match, (-1, -1) if the group hasn't matched
+	// every capture of the groups in order, e.g., one for each iteration of a repeated group.
+	// it's only set if the pattern is compiled with Options.CaptureHistory
+	Captures map[string][]Span
+	// the captures nested the same way as the groups in the pattern, the root is the whole match.
+	// it's only set if the pattern is compiled with Options.CaptureHistory
+	Tree  *CaptureNode
+	Edits int // the number of edits the match needed, only approximate patterns can have edits
+}
+
+// CaptureNode is a capture of a group together with the captures of the groups inside it,
+// a repeated inner group has a child for every iteration, under the capture of the outer group it belongs to
+type CaptureNode struct {
+	input    string
+	Group    int    // the number of the group, 0 is the whole match
+	Name     string // the name of the group, empty if the group is unnamed
+	Span     Span
+	Children []*CaptureNode // in the order they were captured
+}
+
+// Text returns the captured text
+func (n *CaptureNode) Text() string {
+	return n.input[n.Span.Start:n.Span.End]
+}
+
+// Submatch returns the position of the group with the given number,
+// and false if the group has not taken part in the match
+func (m Match) Submatch(i int) (Span, bool) {
+	if i < 0 || i >= len(m.Submatches) || m.Submatches[i].Start < 0 {
+		return Span{Start: -1, End: -1}, false
+	}
+	return m.Submatches[i], true
+}
+
+// Text returns the matched text
+func (m Match) Text() string {
+	return m.input[m.Span.Start:m.Span.End]
+}
+
+// GroupText returns the text captured by the group, and false if the group has not taken part in the match,
+// unlike a group that has matched an empty string
+func (m Match) GroupText(groupName string) (string, bool) {
+	span, ok := m.Groups[groupName]
+	if !ok {
+		return "", false
+	}
+	return m.input[span.Start:span.End], true
+}
+
+// newMatch prepares the match of a successful check
+func (s *State) newMatch(inputString string, ctx *regexCheckContext) Match {
+	groups := map[string]Span{}
+	for groupName := range ctx.groups {
+		if captured, ok := ctx.matchedCapture(groupName); ok {
+			groups[groupName] = captured.span(inputString)
+		}
+	}
+
+	submatches := make([]Span, len(s.subexpNames))
+	for i := range submatches {
+		span, ok := groups[strconv.Itoa(i)]
+		if !ok {
+			span = Span{Start: -1, End: -1}
+		}
+		submatches[i] = span
+	}
+
+	var captures map[string][]Span
+	var tree *CaptureNode
+	if s.history {
+		captures = map[string][]Span{}
+		for groupName := range groups {
+			for _, captured := range ctx.capturesOf(groupName) {
+				captures[groupName] = append(captures[groupName], captured.span(inputString))
+			}
+		}
+		tree = s.captureTree(inputString, ctx.trail)
+	}
+
+	return Match{
+		input:      inputString,
+		Span:       groups["0"],
+		Groups:     groups,
+		Submatches: submatches,
+		Captures:   captures,
+		Tree:       tree,
+		Edits:      ctx.editsAtMatch,
+	}
+}
+
+// captureTree builds the tree of the captures from the groups that started and ended on the path of the match
+func (s *State) captureTree(inputString string, trail []groupEvent) *CaptureNode {
+	var root *CaptureNode
+	var open []*CaptureNode
+	for _, event := range trail {
+		if event.start {
+			node := &CaptureNode{
+				input: inputString,
+				Group: event.number,
+				Name:  s.subexpNames[event.number],
+			}
+			if len(open) > 0 {
+				parent := open[len(open)-1]
+				parent.Children = append(parent.Children, node)
+			} else if root == nil {
+				root = node
+			}
+			open = append(open, node)
+			continue
+		}
+
+		// the innermost group that is open is the one that ends
+		if len(open) > 0 {
+			open[len(open)-1].Span = event.captured.span(inputString)
+			open = open[:len(open)-1]
+		}
+	}
+	return root
+}
+
+// Find returns the leftmost match in the inputString, and false if there's none
+func (s *State) Find(inputString string) (Match, bool) {
+	checkContext := newCheckContext()
+	if !s.search(inputString, 0, len(inputString), checkContext) {
+		return Match{}, false
+	}
+	return s.newMatch(inputString, checkContext), true
+}
+
+// FindAll returns all the successive, non-overlapping matches in the inputString,
+// the same ones as FindMatches
+func (s *State) FindAll(inputString string) []Match {
+	var matches []Match
+	s.forEachMatch(inputString, 0, len(inputString), func(ctx *regexCheckContext) {
+		matches = append(matches, s.newMatch(inputString, ctx))
+	})
+	return matches
+}
+
+// FindIn returns the leftmost match inside the inputString[start:end], and false if there's none.
+// unlike searching in the slice, the text around the window is still visible to the anchors
+func (s *State) FindIn(inputString string, start, end int) (Match, bool) {
+	start, end = clampWindow(inputString, start, end)
+	checkContext := newCheckContext()
+	checkContext.maxEnd = end
+	if !s.search(inputString, start, end, checkContext) {
+		return Match{}, false
+	}
+	return s.newMatch(inputString, checkContext), true
+}
+
+// FindAllIn returns all the successive, non-overlapping matches inside the inputString[start:end],
+// the text around the window is still visible to the anchors
+func (s *State) FindAllIn(inputString string, start, end int) []Match {
+	start, end = clampWindow(inputString, start, end)
+	var matches []Match
+	s.forEachMatch(inputString, start, end, func(ctx *regexCheckContext) {
+		matches = append(matches, s.newMatch(inputString, ctx))
+	})
+	return matches
+}
+
+// MatchAt returns the match that starts exactly at pos, and false if there's none
+func (s *State) MatchAt(inputString string, pos int) (Match, bool) {
+	if pos < 0 || pos > len(inputString) {
+		return Match{}, false
+	}
+	checkContext := newCheckContext()
+	if !s.matchAt(inputString, pos, checkContext) {
+		return Match{}, false
+	}
+	return s.newMatch(inputString, checkContext), true
+}
+
+// clampWindow keeps the window inside the input
+func clampWindow(inputString string, start, end int) (int, int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(inputString) {
+		end = len(inputString)
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// FindAllOverlapping returns the match that starts at each position of the inputString,
+// so unlike FindAll, the matches can overlap. every match still sees the whole input, e.g., for the anchors
+func (s *State) FindAllOverlapping(inputString string) []Match {
+	var matches []Match
+	for pos := 0; pos <= len(inputString); pos++ {
+		if !s.canStartAt(inputString, pos) {
+			continue
+		}
+		checkContext := newCheckContext()
+		if s.matchAt(inputString, pos, checkContext) {
+			matches = append(matches, s.newMatch(inputString, checkContext))
+		}
+	}
+	return matches
+}
+
+// FindAllOverlappingSpans returns every distinct span of the inputString that matches,
+// ordered by the start and then by the end
+func (s *State) FindAllOverlappingSpans(inputString string) []Span {
+	var spans []Span
+	for pos := 0; pos <= len(inputString); pos++ {
+		if !s.canStartAt(inputString, pos) {
+			continue
+		}
+		for _, end := range s.matchEnds(inputString, pos) {
+			spans = append(spans, Span{Start: pos, End: end})
+		}
+	}
+	return spans
+}
+
+// matchEnds returns the ends of all the matches that start at pos in the increasing order.
+// a regular pattern is run as an automaton, every path is explored for the others
+func (s *State) matchEnds(inputString string, pos int) []int {
+	checkContext := newCheckContext()
+
+	var ends []int
+	if s.regular {
+		run := newOperandRun(s, inputString, pos, checkContext)
+		for end := pos; end <= len(inputString) && !run.finished(); end++ {
+			if run.step(end) {
+				ends = append(ends, end)
+			}
+		}
+		return ends
+	}
+
+	checkContext.ends = map[int]bool{}
+	s.check(inputString, pos, checkContext)
+	for end := range checkContext.ends {
+		ends = append(ends, end)
+	}
+	sort.Ints(ends)
+	return ends
+}
+
+// FindLast returns the first match FindEachReverse yields, and false if there's none.
+// it's not always the match that starts at the rightmost position, e.g., aa on "aaa" gives {0 2}, not {1 3},
+// the same as the last match of FindAll
+func (s *State) FindLast(inputString string) (Match, bool) {
+	var last Match
+	found := false
+	s.FindEachReverse(inputString, func(match Match) bool {
+		last, found = match, true
+		return false
+	})
+	return last, found
+}
+
+// FindEachReverse calls the 'yield' function with the matches of FindAll in the reverse order, until it returns false.
+// the input is read backward with the reversed NFA to find where the last run of overlapping matches begins,
+// and the matches of that run are then found forward from its start, like FindAll does.
+// so a long run of overlapping matches is walked back to its beginning before the last of them is yielded.
+// the patterns that can't be reversed, e.g., with backreferences, are searched forward over the whole input first
+func (s *State) FindEachReverse(inputString string, yield func(match Match) bool) {
+	nfa, ok := s.reversed()
+	if !ok {
+		matches := s.FindAll(inputString)
+		for i := len(matches) - 1; i >= 0; i-- {
+			if !yield(matches[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	limit := len(inputString)
+	nextRunStart := len(inputString) + 1 // the matches starting here belong to the run after this one
+	var held *Match
+
+	// yieldRun yields the matches between the start and the limit, and returns false if the yield function stopped
+	yieldRun := func(start int) bool {
+		var run []Match
+		s.forEachMatch(inputString, start, limit, func(ctx *regexCheckContext) {
+			if found := s.newMatch(inputString, ctx); found.Span.Start < nextRunStart {
+				run = append(run, found)
+			}
+		})
+		limit, nextRunStart = start, start
+		if len(run) == 0 {
+			return true
+		}
+
+		// FindAll skips an empty match right after another match, so the empty one at the start of
+		// the previous run waits until the last match of this run is known
+		if held != nil {
+			last := run[len(run)-1]
+			if last.Span.End != held.Span.Start || last.Span.Start == last.Span.End {
+				if !yield(*held) {
+					return false
+				}
+			}
+			held = nil
+		}
+		for i := len(run) - 1; i > 0; i-- {
+			if !yield(run[i]) {
+				return false
+			}
+		}
+		if run[0].Span.Start == run[0].Span.End {
+			held = &run[0]
+			return true
+		}
+		return yield(run[0])
+	}
+
+	// the starts of the runs found so far, from the right to the left. a run is complete when no match
+	// from further back can reach into it, as the matches of FindAll can't cross its start then
+	var runStarts []int
+	reverse := newReverseRun(nfa, inputString)
+	for pos := len(inputString); pos >= 0; pos-- {
+		end, ok := reverse.step(pos)
+		if ok && s.canStartAt(inputString, pos) {
+			// the match joins the runs it reaches into
+			for len(runStarts) > 0 && runStarts[len(runStarts)-1] < end {
+				runStarts = runStarts[:len(runStarts)-1]
+			}
+			runStarts = append(runStarts, pos)
+		}
+
+		furthestEnd := reverse.furthestEnd()
+		for len(runStarts) > 0 && runStarts[0] >= furthestEnd {
+			if !yieldRun(runStarts[0]) {
+				return
+			}
+			runStarts = runStarts[1:]
+		}
+	}
+	if held != nil {
+		yield(*held)
+	}
+}
+
+// canStartAt checks if a match can start at pos, it can't start in the middle of a character
+func (s *State) canStartAt(inputString string, pos int) bool {
+	return !s.unicode || pos >= len(inputString) || utf8.RuneStart(inputString[pos])
+}
+
+// FindIndex returns the start and the end of the leftmost match, or nil if there's none
+func (s *State) FindIndex(inputString string) []int {
+	match, ok := s.Find(inputString)
+	if !ok {
+		return nil
+	}
+	return []int{match.Span.Start, match.Span.End}
+}
+
+// FindAllIndex returns the start and the end of all the successive, non-overlapping matches
+func (s *State) FindAllIndex(inputString string) [][]int {
+	var indexes [][]int
+	for _, match := range s.FindAll(inputString) {
+		indexes = append(indexes, []int{match.Span.Start, match.Span.End})
+	}
+	return indexes
+}
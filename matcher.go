@@ -0,0 +1,54 @@
+package rgx
+
+// Matcher goes through the matches of a pattern in an input one at a time,
+// the matches are the same as the ones FindAll returns, but each is only searched for when it's needed
+type Matcher struct {
+	pattern *State
+	input   string
+	cursor  matchCursor
+	match   Match
+}
+
+// Matcher creates a matcher that goes through the matches in the inputString
+func (s *State) Matcher(inputString string) *Matcher {
+	m := &Matcher{pattern: s}
+	m.Reset(inputString)
+	return m
+}
+
+// Reset starts over with the given inputString
+func (m *Matcher) Reset(inputString string) {
+	m.input = inputString
+	m.cursor = matchCursor{start: 0, end: len(inputString), previousEnd: -1}
+	m.match = Match{}
+}
+
+// Next finds the next match, and returns false if there are no more matches
+func (m *Matcher) Next() bool {
+	checkContext, ok := m.pattern.nextMatch(m.input, &m.cursor)
+	if !ok {
+		m.match = Match{}
+		return false
+	}
+	m.match = m.pattern.newMatch(m.input, checkContext)
+	return true
+}
+
+// Match returns the match that the last call to Next has found
+func (m *Matcher) Match() Match {
+	return m.match
+}
+
+// FindN returns the first n successive, non-overlapping matches in the inputString,
+// or all of them if n is negative
+func (s *State) FindN(inputString string, n int) []Match {
+	var matches []Match
+	m := s.Matcher(inputString)
+	for n < 0 || len(matches) < n {
+		if !m.Next() {
+			break
+		}
+		matches = append(matches, m.Match())
+	}
+	return matches
+}
@@ -5,14 +5,40 @@ import (
 )
 
 type group struct {
-	names []string
-	start bool
-	end   bool
+	number   int // the number of the group, it's not set if the group doesn't have any names
+	names    []string
+	start    bool
+	end      bool
+	balances []string // the names of the group whose latest capture is popped when this group ends, the given one first
+}
+
+// conditionalBranch picks the path depending on whether the group has been captured
+type conditionalBranch struct {
+	name string
+	yes  *State
+	no   *State
 }
 
 type backreference struct {
-	name   string
-	target *State
+	name            string
+	caseInsensitive bool
+	unicode         bool
+	target          *State
+}
+
+// spanOperator is a transition that consumes a whole span of the input at once,
+// the spans are found by running the operand NFAs as automata, see operandRun.
+// it's used by the extended syntax: intersection, complement and absent operators
+type spanOperator struct {
+	kind     regexTokenType
+	operands []*State
+	target   *State
+}
+
+// fuzzyBoundary marks the entry and the exit of an approximate part of the pattern
+type fuzzyBoundary struct {
+	enter    bool
+	maxEdits int
 }
 
 type State struct {
@@ -20,23 +46,63 @@ type State struct {
 	terminal      bool
 	endOfText     bool
 	startOfText   bool
-	transitions   map[uint8][]*State
+	multiline     bool // the anchors match at the newlines as well
+	crlf          bool // the anchors treat \r\n as a single newline
+	transitions   map[symbol][]*State
+	class         *characterClass
+	loop          *State // the start of the next iteration of an unbounded quantifier, tried before anything else
 	groups        []*group
 	backreference *backreference
+	operator      *spanOperator
+	condition     *conditionalBranch
+	fuzzy         *fuzzyBoundary
+	maxEdits      int      // only set for the start state: the sum of all the edit budgets in the pattern
+	longest       bool     // only set for the start state: the POSIX leftmost-longest mode
+	unicode       bool     // only set for the start state: the input is UTF-8
+	subexpNames   []string // only set for the start state: the names of the groups by their numbers
+	history       bool     // only set for the start state: the results keep every capture of the groups
+	regular       bool     // only set for the start state: the paths can be run at the same time, see posixRun and operandRun
 }
 
+// symbol is what the transitions are keyed by: a byte of the input (0-255)
+// or one of the special transitions, which are kept outside the byte range
+// so that every byte value can be matched literally
+type symbol int
+
+const epsilonChar symbol = -1 // does not consume any input
+
+// getChar returns these markers for the positions outside the input,
+// they are never used as transition keys
 const (
-	epsilonChar = 0
-	startOfText = 1
-	endOfText   = 2
-	anyChar     = 3
-	newline     = 10
+	startOfText symbol = -3
+	endOfText   symbol = -4
 )
 
+const newline symbol = '\n'
+
 func toNfa(parseContext *parsingContext) (*State, *RegexError) {
+	if parseContext.approximateEdits > 0 {
+		// (?e<=k) makes the whole pattern approximate
+		parseContext.tokens = []regexToken{{
+			tokenType: fuzzy,
+			value: fuzzyPayload{
+				maxEdits: parseContext.approximateEdits,
+				value: regexToken{
+					tokenType: groupUncaptured,
+					value:     parseContext.tokens,
+				},
+			},
+		}}
+	}
+
+	if len(parseContext.tokens) == 0 {
+		// e.g., a pattern that only has inline flags, it matches the empty string
+		parseContext.tokens = []regexToken{emptyToken()}
+	}
+
 	token := parseContext.tokens[0]
 	startState, endState, err := tokenToNfa(token, parseContext, &State{
-		transitions: map[uint8][]*State{},
+		transitions: map[symbol][]*State{},
 	})
 
 	if err != nil {
@@ -52,8 +118,13 @@ func toNfa(parseContext *parsingContext) (*State, *RegexError) {
 	}
 
 	start := &State{
-		start: true,
-		transitions: map[uint8][]*State{
+		start:       true,
+		maxEdits:    parseContext.totalEdits,
+		longest:     parseContext.options.Longest,
+		unicode:     parseContext.options.Unicode,
+		subexpNames: *parseContext.groupNames,
+		history:     parseContext.options.CaptureHistory,
+		transitions: map[symbol][]*State{
 			epsilonChar: {startState},
 		},
 		groups: []*group{{
@@ -64,7 +135,7 @@ func toNfa(parseContext *parsingContext) (*State, *RegexError) {
 	}
 
 	end := &State{
-		transitions: map[uint8][]*State{},
+		transitions: map[symbol][]*State{},
 		terminal:    true,
 		groups: []*group{
 			{
@@ -76,29 +147,63 @@ func toNfa(parseContext *parsingContext) (*State, *RegexError) {
 	}
 
 	endState.transitions[epsilonChar] = append(endState.transitions[epsilonChar], end)
+	start.regular = checkRegularOperand(start) == nil
 
 	return start, nil
 }
 
+// emptyToken matches the empty string
+func emptyToken() regexToken {
+	return regexToken{
+		tokenType: groupUncaptured,
+		value:     []regexToken{},
+	}
+}
+
+// operandToNfa builds a standalone NFA for the given token
+// that is used to check if a span of the input matches it
+func operandToNfa(token regexToken, parseContext *parsingContext) (*State, *RegexError) {
+	start, end, err := tokenToNfa(token, parseContext, &State{
+		transitions: map[symbol][]*State{},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	end.transitions[epsilonChar] = append(end.transitions[epsilonChar], &State{
+		transitions: map[symbol][]*State{},
+		terminal:    true,
+	})
+
+	return start, nil
+}
+
+// branchToNfa builds the NFA for the given token and connects its end to the 'to' state
+func branchToNfa(token regexToken, parseContext *parsingContext, to *State) (*State, *RegexError) {
+	start, end, err := tokenToNfa(token, parseContext, &State{
+		transitions: map[symbol][]*State{},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	end.transitions[epsilonChar] = append(end.transitions[epsilonChar], to)
+	return start, nil
+}
+
 func tokenToNfa(token regexToken, parseContext *parsingContext, startFrom *State) (*State, *State, *RegexError) {
 	switch token.tokenType {
 	case literal:
 		value := token.value.(uint8)
 		to := &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		}
-		startFrom.transitions[value] = []*State{to}
+		startFrom.transitions[symbol(value)] = []*State{to}
 		return startFrom, to, nil
 	case quantifier:
 		return handleQuantifierToToken(token, parseContext, startFrom)
-	case wildcard:
-		to := &State{
-			transitions: map[uint8][]*State{},
-		}
-
-		startFrom.transitions[anyChar] = []*State{to}
-
-		return startFrom, to, nil
 	case or:
 		values := token.value.([]regexToken)
 		_, end1, err := tokenToNfa(values[0], parseContext, startFrom)
@@ -111,7 +216,7 @@ func tokenToNfa(token regexToken, parseContext *parsingContext, startFrom *State
 		}
 
 		to := &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		}
 
 		end1.transitions[epsilonChar] = append(end1.transitions[epsilonChar], to)
@@ -120,10 +225,13 @@ func tokenToNfa(token regexToken, parseContext *parsingContext, startFrom *State
 		return startFrom, to, nil
 	case groupCaptured:
 		v := token.value.(groupTokenPayload)
+		if len(v.tokens) == 0 {
+			v.tokens = []regexToken{emptyToken()}
+		}
 
 		// concatenate all the elements in the group
 		start, end, err := tokenToNfa(v.tokens[0], parseContext, &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		})
 
 		if err != nil {
@@ -139,48 +247,64 @@ func tokenToNfa(token regexToken, parseContext *parsingContext, startFrom *State
 		}
 		// concatenation ends
 
-		groupNameNumeric := fmt.Sprintf("%d", parseContext.nextGroup())
-		groupNameUserSet := v.name
+		var balancedNames []string
+		if v.balances != "" {
+			if _, ok := parseContext.capturedGroups[v.balances]; !ok {
+				return nil, nil, &RegexError{
+					Code:    CompilationError,
+					Message: fmt.Sprintf("Group (%s) does not exist", v.balances),
+				}
+			}
+			balancedNames = parseContext.groupAliases(v.balances)
+		}
 
-		groupNames := []string{groupNameNumeric}
-		parseContext.capturedGroups[groupNameNumeric] = true
-		if groupNameUserSet != "" {
-			groupNames = append(groupNames, groupNameUserSet)
-			parseContext.capturedGroups[groupNameUserSet] = true
+		var groupNames []string
+		// (?<-name>) only pops the other group, it does not capture anything itself
+		if v.number != 0 {
+			// every copy of a repeated group has the same number
+			groupNameNumeric := fmt.Sprintf("%d", v.number)
+			groupNameUserSet := v.name
+
+			groupNames = []string{groupNameNumeric}
+			parseContext.capturedGroups[groupNameNumeric] = true
+			if groupNameUserSet != "" {
+				groupNames = append(groupNames, groupNameUserSet)
+				parseContext.capturedGroups[groupNameUserSet] = true
+			}
 		}
 
-		if startFrom.groups != nil {
-			startFrom.groups = append(startFrom.groups, &group{
-				names: groupNames,
-				start: true,
-			})
-		} else {
-			startFrom.groups = []*group{{
-				names: groupNames,
-				start: true,
-			}}
+		// the boundaries of the group are states of their own, so that they are passed once
+		// for every capture, even if the 'startFrom' or the 'end' is the loop of a quantifier
+		groupStart := &State{
+			transitions: map[symbol][]*State{
+				epsilonChar: {start},
+			},
+			groups: []*group{{
+				number: v.number,
+				names:  groupNames,
+				start:  true,
+			}},
 		}
 
-		if end.groups != nil {
-			end.groups = append(end.groups, &group{
-				names: groupNames,
-				end:   true,
-			})
-		} else {
-			end.groups = []*group{{
-				names: groupNames,
-				end:   true,
-			}}
+		groupEnd := &State{
+			transitions: map[symbol][]*State{},
+			groups: []*group{{
+				number:   v.number,
+				names:    groupNames,
+				end:      true,
+				balances: balancedNames,
+			}},
 		}
 
-		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], start)
-		return startFrom, end, nil
+		end.transitions[epsilonChar] = append(end.transitions[epsilonChar], groupEnd)
+		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], groupStart)
+		return startFrom, groupEnd, nil
 	case groupUncaptured:
 		values := token.value.([]regexToken)
 
 		if len(values) == 0 {
 			end := &State{
-				transitions: map[uint8][]*State{},
+				transitions: map[symbol][]*State{},
 			}
 
 			startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], end)
@@ -188,7 +312,7 @@ func tokenToNfa(token regexToken, parseContext *parsingContext, startFrom *State
 		}
 
 		start, end, err := tokenToNfa(values[0], parseContext, &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		})
 
 		if err != nil {
@@ -207,60 +331,165 @@ func tokenToNfa(token regexToken, parseContext *parsingContext, startFrom *State
 
 		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], start)
 		return startFrom, end, nil
-	case bracket:
-		constructTokens := token.value.(map[uint8]bool)
+	case bracket, bracketNot, wildcard:
+		payload := token.value.(characterClassPayload)
 
 		to := &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		}
 
-		for ch := range constructTokens {
-			startFrom.transitions[ch] = []*State{to}
+		// the class is kept in its own state, so that it does not
+		// replace the class of another branch that starts from the same state
+		classState := &State{
+			transitions: map[symbol][]*State{},
+			class: &characterClass{
+				ranges:  payload.ranges,
+				negated: token.tokenType != bracket,
+				unicode: payload.unicode,
+				target:  to,
+			},
 		}
 
+		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], classState)
+		return startFrom, to, nil
+	case textBeginning, textEnd:
+		// the anchor is a state of its own, the 'startFrom' can be
+		// shared with other paths, e.g., the loop of a quantifier
+		payload := token.value.(anchorPayload)
+		to := &State{
+			transitions: map[symbol][]*State{},
+		}
+		anchor := &State{
+			transitions: map[symbol][]*State{
+				epsilonChar: {to},
+			},
+			startOfText: token.tokenType == textBeginning,
+			endOfText:   token.tokenType == textEnd,
+			multiline:   payload.multiline,
+			crlf:        payload.crlf,
+		}
+		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], anchor)
 		return startFrom, to, nil
-	case bracketNot:
-		constructTokens := token.value.(map[uint8]bool)
+	case backReference:
+		payload := token.value.(backreferencePayload)
+		if _, ok := parseContext.capturedGroups[payload.name]; !ok {
+			return nil, nil, &RegexError{
+				Code:    CompilationError,
+				Message: fmt.Sprintf("Group (%s) does not exist", payload.name),
+			}
+		}
+		to := &State{
+			transitions: map[symbol][]*State{},
+		}
+
+		startFrom.backreference = &backreference{
+			name:            payload.name,
+			caseInsensitive: payload.caseInsensitive,
+			unicode:         payload.unicode,
+			target:          to,
+		}
+
+		return startFrom, to, nil
+	case conditional:
+		payload := token.value.(conditionalPayload)
+		if _, ok := parseContext.capturedGroups[payload.name]; !ok {
+			return nil, nil, &RegexError{
+				Code:    CompilationError,
+				Message: fmt.Sprintf("Group (%s) does not exist", payload.name),
+			}
+		}
 
 		to := &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
+		}
+
+		yes, err := branchToNfa(payload.yes, parseContext, to)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		deadEnd := &State{
-			transitions: map[uint8][]*State{},
+		no, err := branchToNfa(payload.no, parseContext, to)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		for ch := range constructTokens {
-			startFrom.transitions[ch] = []*State{deadEnd}
+		// the decision is made in its own state, so that it does not block
+		// the other transitions of the 'startFrom', e.g., the loop of a quantifier
+		decision := &State{
+			transitions: map[symbol][]*State{},
+			condition: &conditionalBranch{
+				name: payload.name,
+				yes:  yes,
+				no:   no,
+			},
 		}
-		startFrom.transitions[anyChar] = []*State{to}
 
+		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], decision)
 		return startFrom, to, nil
-	case textBeginning:
+	case fail:
+		// nothing connects the start to the end, so every path through here fails
 		to := &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		}
-		startFrom.startOfText = true
-		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], to)
 		return startFrom, to, nil
-	case textEnd:
-		startFrom.endOfText = true
-		return startFrom, startFrom, nil
-	case backReference:
-		groupName := token.value.(string)
-		if _, ok := parseContext.capturedGroups[groupName]; !ok {
-			return nil, nil, &RegexError{
-				Code:    CompilationError,
-				Message: fmt.Sprintf("Group (%s) does not exist", groupName),
+	case fuzzy:
+		payload := token.value.(fuzzyPayload)
+		parseContext.totalEdits += payload.maxEdits
+
+		enter := &State{
+			transitions: map[symbol][]*State{},
+			fuzzy: &fuzzyBoundary{
+				enter:    true,
+				maxEdits: payload.maxEdits,
+			},
+		}
+
+		start, end, err := tokenToNfa(payload.value, parseContext, &State{
+			transitions: map[symbol][]*State{},
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		exit := &State{
+			transitions: map[symbol][]*State{},
+			fuzzy:       &fuzzyBoundary{},
+		}
+
+		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], enter)
+		enter.transitions[epsilonChar] = append(enter.transitions[epsilonChar], start)
+		end.transitions[epsilonChar] = append(end.transitions[epsilonChar], exit)
+
+		return startFrom, exit, nil
+	case intersection, complement, absent:
+		var operandTokens []regexToken
+		if token.tokenType == intersection {
+			operandTokens = token.value.([]regexToken)
+		} else {
+			operandTokens = []regexToken{token.value.(regexToken)}
+		}
+
+		var operands []*State
+		for _, operandToken := range operandTokens {
+			operand, err := operandToNfa(operandToken, parseContext)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := checkRegularOperand(operand); err != nil {
+				return nil, nil, err
 			}
+			operands = append(operands, operand)
 		}
+
 		to := &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		}
 
-		startFrom.backreference = &backreference{
-			name:   groupName,
-			target: to,
+		startFrom.operator = &spanOperator{
+			kind:     token.tokenType,
+			operands: operands,
+			target:   to,
 		}
 
 		return startFrom, to, nil
@@ -280,11 +509,7 @@ func handleQuantifierToToken(token regexToken, parseContext *parsingContext, sta
 	max := payload.max
 
 	to := &State{
-		transitions: map[uint8][]*State{},
-	}
-
-	if min == 0 {
-		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], to)
+		transitions: map[symbol][]*State{},
 	}
 
 	// how many times should the NFA be generated in the bigger state machine
@@ -301,20 +526,24 @@ func handleQuantifierToToken(token regexToken, parseContext *parsingContext, sta
 	}
 	var value = payload.value
 	previousStart, previousEnd, err := tokenToNfa(value, parseContext, &State{
-		transitions: map[uint8][]*State{},
+		transitions: map[symbol][]*State{},
 	})
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// the quantifiers are greedy: entering the repetition is tried before skipping it
 	startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], previousStart)
+	if min == 0 {
+		startFrom.transitions[epsilonChar] = append(startFrom.transitions[epsilonChar], to)
+	}
 
 	// starting from 2, because the one above is the first one
 	for i := 2; i <= total; i++ {
 		// the same NFA needs to be generated 'total' times
 		start, end, err := tokenToNfa(value, parseContext, &State{
-			transitions: map[uint8][]*State{},
+			transitions: map[symbol][]*State{},
 		})
 
 		if err != nil {
@@ -338,7 +567,53 @@ func handleQuantifierToToken(token regexToken, parseContext *parsingContext, sta
 
 	previousEnd.transitions[epsilonChar] = append(previousEnd.transitions[epsilonChar], to)
 	if max == quantifierInfinity {
-		to.transitions[epsilonChar] = append(to.transitions[epsilonChar], previousStart)
+		to.loop = previousStart
 	}
 	return startFrom, to, nil
 }
+
+// countStates returns the number of the states reachable from this one,
+// including the operand NFAs of the span operators
+func (s *State) countStates() int {
+	count := 0
+	s.forEachState(func(*State) {
+		count++
+	})
+	return count
+}
+
+// forEachState calls the 'visit' function with every state reachable from this one once,
+// including the operand NFAs of the span operators
+func (s *State) forEachState(visit func(state *State)) {
+	seen := map[*State]bool{}
+	pending := []*State{s}
+	for len(pending) > 0 {
+		state := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if state == nil || seen[state] {
+			continue
+		}
+		seen[state] = true
+		visit(state)
+
+		for _, states := range state.transitions {
+			pending = append(pending, states...)
+		}
+		if state.class != nil {
+			pending = append(pending, state.class.target)
+		}
+		if state.loop != nil {
+			pending = append(pending, state.loop)
+		}
+		if state.backreference != nil {
+			pending = append(pending, state.backreference.target)
+		}
+		if state.condition != nil {
+			pending = append(pending, state.condition.yes, state.condition.no)
+		}
+		if state.operator != nil {
+			pending = append(pending, state.operator.target)
+			pending = append(pending, state.operator.operands...)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package rgx
+
+import "fmt"
+
+// operatorStart is a span operator applied at a position of the input
+type operatorStart struct {
+	operator *spanOperator
+	pos      int
+}
+
+// check continues from the target state with the spans the operator accepts at pos,
+// from the longest to the shortest
+func (o *spanOperator) check(inputString string, pos int, ctx *regexCheckContext) bool {
+	ends := o.ends(inputString, pos, ctx)
+	for i := len(ends) - 1; i >= 0; i-- {
+		if o.target.check(inputString, ends[i], ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// ends returns the ends of the spans starting at pos that the operator accepts, in the increasing order.
+// the operands are run over the input once, on all of their paths at the same time:
+//   - A&B is the product of the operands, a span is accepted where all of them accept it
+//   - ~A is the complement of the operand, a span is accepted where it doesn't accept it
+//   - (?~A) starts the operand at every position of the span, and stops at the first match it finds
+func (o *spanOperator) ends(inputString string, pos int, ctx *regexCheckContext) []int {
+	key := operatorStart{operator: o, pos: pos}
+	if ends, ok := ctx.operatorEnds[key]; ok {
+		return ends
+	}
+
+	var ends []int
+	switch o.kind {
+	case intersection:
+		var runs []*operandRun
+		for _, operand := range o.operands {
+			runs = append(runs, newOperandRun(operand, inputString, pos, ctx))
+		}
+		for end := pos; end <= len(inputString); end++ {
+			accepted, finished := true, false
+			for _, run := range runs {
+				if !run.step(end) {
+					accepted = false
+				}
+				finished = finished || run.finished()
+			}
+			if accepted {
+				ends = append(ends, end)
+			}
+			if finished {
+				// one of the operands can't match any longer span
+				break
+			}
+		}
+	case complement:
+		run := newOperandRun(o.operands[0], inputString, pos, ctx)
+		for end := pos; end <= len(inputString); end++ {
+			if !run.step(end) {
+				ends = append(ends, end)
+			}
+			if run.finished() {
+				// the operand can't match any longer span, so all of them are accepted
+				for end++; end <= len(inputString); end++ {
+					ends = append(ends, end)
+				}
+				break
+			}
+		}
+	case absent:
+		run := newOperandRun(o.operands[0], inputString, pos, ctx)
+		for end := pos; end <= len(inputString); end++ {
+			// a match of the operand can start anywhere in the span
+			run.add(end, o.operands[0])
+			if run.step(end) {
+				// this span and all the longer ones contain the match
+				break
+			}
+			ends = append(ends, end)
+		}
+	}
+
+	ctx.operatorEnds[key] = ends
+	return ends
+}
+
+// operandRun simulates an operand NFA on all of its paths at once, the same as the DFA
+// made by the subset construction would, but only the subsets the input reaches are made
+type operandRun struct {
+	input   string
+	pending map[int]map[*State]bool // the states each position continues from
+	ctx     *regexCheckContext
+}
+
+func newOperandRun(operand *State, inputString string, pos int, ctx *regexCheckContext) *operandRun {
+	run := &operandRun{
+		input:   inputString,
+		pending: map[int]map[*State]bool{},
+		ctx:     ctx,
+	}
+	run.add(pos, operand)
+	return run
+}
+
+// add continues from the state at pos
+func (r *operandRun) add(pos int, state *State) {
+	if r.pending[pos] == nil {
+		r.pending[pos] = map[*State]bool{}
+	}
+	r.pending[pos][state] = true
+}
+
+// finished checks if the run has nothing to continue from
+func (r *operandRun) finished() bool {
+	return len(r.pending) == 0
+}
+
+// step follows the epsilon transitions from the states at pos, and moves the states
+// that consume the character at pos forward. it returns true if the terminal state is reached at pos.
+// the positions must be stepped in the increasing order
+func (r *operandRun) step(pos int) bool {
+	var stack []*State
+	for state := range r.pending[pos] {
+		stack = append(stack, state)
+	}
+	delete(r.pending, pos)
+
+	accepted := false
+	visited := map[*State]bool{}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[s] {
+			continue
+		}
+		visited[s] = true
+
+		if s.endOfText && pos != len(r.input) && !(s.multiline && s.isLineEnd(r.input, pos)) {
+			continue
+		}
+		if s.startOfText && pos != 0 && !(s.multiline && s.isLineStart(r.input, pos)) {
+			continue
+		}
+		if s.terminal {
+			accepted = true
+			continue
+		}
+
+		if s.operator != nil {
+			for _, end := range s.operator.ends(r.input, pos, r.ctx) {
+				if end == pos {
+					stack = append(stack, s.operator.target)
+				} else {
+					r.add(end, s.operator.target)
+				}
+			}
+		}
+
+		if s.loop != nil {
+			stack = append(stack, s.loop)
+		}
+
+		if pos < len(r.input) {
+			if nextState := s.nextStateWith(symbol(r.input[pos])); nextState != nil {
+				r.add(pos+1, nextState)
+			} else if s.class != nil {
+				if size, ok := s.class.match(r.input, pos); ok {
+					r.add(pos+size, s.class.target)
+				}
+			}
+		}
+
+		stack = append(stack, s.transitions[epsilonChar]...)
+	}
+	return accepted
+}
+
+// checkRegularOperand makes sure that the operand can be run by operandRun,
+// the features that depend on the captures or on the path that matched can't be
+func checkRegularOperand(operand *State) *RegexError {
+	var feature string
+	operand.forEachState(func(state *State) {
+		switch {
+		case state.backreference != nil:
+			feature = "Backreferences"
+		case state.condition != nil:
+			feature = "Conditionals"
+		case state.fuzzy != nil:
+			feature = "Approximate parts"
+		}
+		for _, capturedGroup := range state.groups {
+			if len(capturedGroup.balances) > 0 {
+				feature = "Balancing groups"
+			}
+		}
+	})
+
+	if feature != "" {
+		return &RegexError{
+			Code:    CompilationError,
+			Message: fmt.Sprintf("%s can't be used inside the operands of &, ~ and (?~)", feature),
+		}
+	}
+	return nil
+}
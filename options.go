@@ -0,0 +1,173 @@
+package rgx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect selects the syntax the pattern is written in
+type Dialect uint8
+
+const (
+	Perl          Dialect = iota // Perl/PCRE-like syntax, the default, every feature is available
+	RE2                          // like Perl, but without backreferences and other backtracking-only features
+	PosixExtended                // POSIX ERE: no (?...) groups and no backreferences
+	PosixBasic                   // POSIX BRE: \( \) and \{ \} are the groups and bounds, ( ) { } + ? | are literals
+	ECMAScript                   // JavaScript syntax: named groups and backreferences, no other (?...) groups
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case Perl:
+		return "Perl"
+	case RE2:
+		return "RE2"
+	case PosixExtended:
+		return "POSIX ERE"
+	case PosixBasic:
+		return "POSIX BRE"
+	case ECMAScript:
+		return "ECMAScript"
+	default:
+		return fmt.Sprintf("Dialect(%d)", d)
+	}
+}
+
+// Options control how a pattern is compiled, the zero value is the Perl syntax without any flags
+type Options struct {
+	Dialect         Dialect
+	CaseInsensitive bool // letters match their other case as well
+	Multiline       bool // ^ and $ match at the beginning and the end of every line, not only of the input
+	DotAll          bool // . and the negated brackets match the newline as well
+	CRLF            bool // \r\n is a single line terminator for the anchors, and so is \r alone, . and the negated brackets don't match \r either
+	Unicode         bool // the pattern and the input are UTF-8, . and the brackets match whole characters
+	Extended        bool // enables the intersection (A&B), complement (~A) and absent ((?~A)) operators
+	CaptureHistory  bool // the results keep every capture of the repeated groups, not only the last one
+	Longest         bool // the longest match at the leftmost position wins, the groups follow the POSIX rules
+	MaxRepeat       int  // the maximum bound of a counted quantifier such as {m,n}, 0 means no limit
+	MaxStates       int  // the maximum number of the NFA states, 0 means no limit
+}
+
+// supports checks if the dialect has the given feature
+func (d Dialect) supports(feature dialectFeature) bool {
+	switch d {
+	case Perl:
+		return true
+	case RE2:
+		return feature == namedGroups || feature == inlineFlags || feature == classEscapes ||
+			feature == bracketEscapes || feature == bracketClasses
+	case ECMAScript:
+		return feature == namedGroups || feature == backreferences || feature == classEscapes || feature == bracketEscapes
+	case PosixBasic:
+		return feature == backreferences || feature == bracketClasses
+	case PosixExtended:
+		return feature == bracketClasses
+	default:
+		return false
+	}
+}
+
+type dialectFeature uint8
+
+const (
+	namedGroups     dialectFeature = iota // (?<name>...)
+	backreferences                        // \1 and \k<name>
+	groupExtensions                       // balancing groups, conditionals, (?!) and (?e<=k)
+	approximate                           // {~k}
+	inlineFlags                           // (?i)
+	classEscapes                          // \d, \w, \s and their negations
+	bracketEscapes                        // \ escapes the next character inside the brackets, it's a literal in POSIX
+	bracketClasses                        // [:digit:] and the other named classes inside the brackets
+)
+
+func unsupportedInDialect(what string, d Dialect, pos int) *RegexError {
+	return &RegexError{
+		Code:    SyntaxError,
+		Message: fmt.Sprintf("%s is not supported in the %s syntax", what, d),
+		Pos:     pos,
+	}
+}
+
+// translateBasic rewrites a POSIX BRE pattern into the default syntax.
+// it also returns the position in the original pattern of every character of the translated one,
+// so that the errors can point to the original pattern
+func translateBasic(regexString string) (string, []int) {
+	var translated []byte
+	var positions []int
+	emit := func(pos int, chars ...byte) {
+		for _, ch := range chars {
+			translated = append(translated, ch)
+			positions = append(positions, pos)
+		}
+	}
+
+	// whether the next character is at the beginning of the pattern or of a group,
+	// where * and ^ have special rules
+	atBeginning := true
+	for i := 0; i < len(regexString); i++ {
+		ch := regexString[i]
+		beginning := atBeginning
+		atBeginning = false
+
+		switch {
+		case ch == '\\' && i+1 < len(regexString):
+			next := regexString[i+1]
+			i++
+			switch next {
+			case '(':
+				emit(i-1, '(')
+				atBeginning = true
+			case ')', '{', '}':
+				emit(i-1, next)
+			default:
+				emit(i-1, '\\', next)
+			}
+		case ch == '[':
+			// brackets are the same in both syntaxes, copy them as is.
+			// ] right after [ or [^ is a literal, and so is the backslash
+			end := i + 1
+			if end < len(regexString) && regexString[end] == '^' {
+				end++
+			}
+			if end < len(regexString) && regexString[end] == ']' {
+				end++
+			}
+			for end < len(regexString) && regexString[end] != ']' {
+				if regexString[end] == '[' && end+1 < len(regexString) && strings.IndexByte(":.=", regexString[end+1]) >= 0 {
+					// skip [:name:], [.x.] and [=x=] as a whole
+					if closing := strings.Index(regexString[end+2:], string(regexString[end+1])+"]"); closing >= 0 {
+						end += 2 + closing + 1
+					}
+				}
+				end++
+			}
+			for j := i; j <= end && j < len(regexString); j++ {
+				emit(j, regexString[j])
+			}
+			i = end
+		case ch == '(' || ch == ')' || ch == '{' || ch == '}' || ch == '+' || ch == '?' || ch == '|':
+			emit(i, '\\', ch)
+		case ch == '*' && beginning:
+			// * at the beginning can't repeat anything, so it's a literal
+			emit(i, '\\', ch)
+		case ch == '^':
+			if i == 0 {
+				emit(i, ch)
+				atBeginning = true
+			} else {
+				emit(i, '\\', ch)
+			}
+		case ch == '$':
+			// $ is an anchor only at the end of the pattern or a group
+			if i == len(regexString)-1 || (i+2 < len(regexString) && regexString[i+1] == '\\' && regexString[i+2] == ')') {
+				emit(i, ch)
+			} else {
+				emit(i, '\\', ch)
+			}
+		default:
+			emit(i, ch)
+		}
+	}
+
+	return string(translated), positions
+}
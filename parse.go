@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type regexTokenType uint8
@@ -20,6 +21,12 @@ const (
 	textEnd                        = iota // $
 	backReference                  = iota // $
 	quantifier                     = iota // {m,n} or {m,}, {m}
+	intersection                   = iota // & (extended syntax)
+	complement                     = iota // ~ (extended syntax)
+	absent                         = iota // (?~) (extended syntax)
+	fuzzy                          = iota // {~k} or (?e<=k), approximate matching
+	conditional                    = iota // (?(name)yes|no)
+	fail                           = iota // (?!), never matches
 )
 
 type regexToken struct {
@@ -33,25 +40,55 @@ type quantifierPayload struct {
 	value regexToken
 }
 
+type fuzzyPayload struct {
+	maxEdits int
+	value    regexToken
+}
+
 type groupTokenPayload struct {
-	tokens []regexToken
-	name   string
+	tokens   []regexToken
+	number   int // 0 if the group doesn't capture anything itself
+	name     string
+	balances string // (?<name-balances>) pops the latest capture of the 'balances' group
+}
+
+type conditionalPayload struct {
+	name string
+	yes  regexToken
+	no   regexToken
+}
+
+type anchorPayload struct {
+	multiline bool
+	crlf      bool
+}
+
+type backreferencePayload struct {
+	name            string
+	caseInsensitive bool
+	unicode         bool
 }
 
 type parsingContext struct {
 	pos            int
 	tokens         []regexToken
-	groupCounter   uint8
+	groupNames     *[]string // the names of the groups by their numbers, shared by the contexts of the nested groups
 	capturedGroups map[string]bool
+	options        Options
+	// the edit budget of the whole pattern, set by (?e<=k)
+	approximateEdits int
+	// the sum of all the edit budgets, it's calculated while building the NFA
+	totalEdits int
 }
 
 func (p *parsingContext) loc() int {
 	return p.pos
 }
 
-func (p *parsingContext) nextGroup() uint8 {
-	p.groupCounter++
-	return p.groupCounter
+// nextGroup registers a new group and returns its number
+func (p *parsingContext) nextGroup(name string) int {
+	*p.groupNames = append(*p.groupNames, name)
+	return len(*p.groupNames) - 1
 }
 
 func (p *parsingContext) adv() int {
@@ -67,6 +104,41 @@ func (p *parsingContext) push(token regexToken) {
 	p.tokens = append(p.tokens, token)
 }
 
+// groupAliases returns the given name of a group followed by its other names:
+// the number of a named group, or the name of a numbered one
+func (p *parsingContext) groupAliases(groupName string) []string {
+	aliases := []string{groupName}
+	for number, name := range *p.groupNames {
+		numeric := strconv.Itoa(number)
+		if number > 0 && name != "" && name == groupName {
+			aliases = append(aliases, numeric)
+		} else if numeric == groupName && name != "" {
+			aliases = append(aliases, name)
+		}
+	}
+	return aliases
+}
+
+// backreferenceTo makes the payload of a backreference to the given group with the current flags
+func (p *parsingContext) backreferenceTo(groupName string) backreferencePayload {
+	return backreferencePayload{
+		name:            groupName,
+		caseInsensitive: p.options.CaseInsensitive,
+		unicode:         p.options.Unicode,
+	}
+}
+
+// lineTerminators returns the characters that the wildcard and the negated brackets don't match
+func (p *parsingContext) lineTerminators() []runeRange {
+	if p.options.DotAll {
+		return nil
+	}
+	if p.options.CRLF {
+		return []runeRange{{from: '\n', to: '\n'}, {from: '\r', to: '\r'}}
+	}
+	return []runeRange{{from: '\n', to: '\n'}}
+}
+
 // removeLast pops the last count number of elements and returns the popped elements
 func (p *parsingContext) removeLast(count int) []regexToken {
 	elementsToBeRemoved := p.tokens[len(p.tokens)-count:]
@@ -74,36 +146,24 @@ func (p *parsingContext) removeLast(count int) []regexToken {
 	return elementsToBeRemoved
 }
 
-func isAlphabetUppercase(ch uint8) bool {
-	return ch >= 'A' && ch <= 'Z'
-}
-
-func isAlphabetLowercase(ch uint8) bool {
-	return ch >= 'a' && ch <= 'z'
-}
-
 func isNumeric(ch uint8) bool {
 	return ch >= '0' && ch <= '9'
 }
 
-var specialChars = map[uint8]bool{
-	'&':  true,
-	'*':  true,
-	' ':  true,
-	'{':  true,
-	'}':  true,
-	'[':  true,
-	']':  true,
-	'(':  true,
-	')':  true,
-	',':  true,
-	'=':  true,
-	'-':  true,
+// metaCharacters have a special meaning outside the brackets,
+// every other printable character is a literal
+var metaCharacters = map[uint8]bool{
+	'\\': true,
+	'^':  true,
+	'$':  true,
 	'.':  true,
+	'|':  true,
+	'?':  true,
+	'*':  true,
 	'+':  true,
-	';':  true,
-	'\\': true,
-	'/':  true,
+	'(':  true,
+	'[':  true,
+	'{':  true,
 }
 
 var mustBeEscapedCharacters = map[uint8]bool{
@@ -122,16 +182,19 @@ var mustBeEscapedCharacters = map[uint8]bool{
 	'}':  true,
 }
 
-func isSpecialChar(ch uint8) bool {
-	_, ok := specialChars[ch]
+func isMetaCharacter(ch uint8) bool {
+	_, ok := metaCharacters[ch]
 	return ok
 }
 
+func isPrintable(ch uint8) bool {
+	return ch >= ' ' && ch <= '~'
+}
+
+// isLiteral checks if the character stands for itself,
+// the bytes outside the ASCII range are literals too, so that UTF-8 encoded characters match as is
 func isLiteral(ch uint8) bool {
-	return isAlphabetUppercase(ch) ||
-		isAlphabetLowercase(ch) ||
-		isNumeric(ch) ||
-		isSpecialChar(ch)
+	return (isPrintable(ch) || ch >= utf8.RuneSelf) && !isMetaCharacter(ch)
 }
 
 func isWildcard(ch uint8) bool {
@@ -154,53 +217,88 @@ func isQuantifier(ch uint8) bool {
 func parseBracket(regexString string, parseContext *parsingContext) *RegexError {
 	var tokenType regexTokenType
 
-	if regexString[parseContext.loc()] == '^' {
+	if parseContext.loc() < len(regexString) && regexString[parseContext.loc()] == '^' {
 		tokenType = bracketNot
 		parseContext.adv()
 	} else {
 		tokenType = bracket
 	}
 
-	var pieces []string
+	var ranges []runeRange
+	// whether the last element is a single character, so that it can become a range
+	lastIsSingle := false
+
+	dialect := parseContext.options.Dialect
+	if (dialect == PosixExtended || dialect == PosixBasic) &&
+		parseContext.loc() < len(regexString) && regexString[parseContext.loc()] == ']' {
+		// in POSIX, ] right after [ or [^ is a literal
+		ranges = append(ranges, runeRange{from: ']', to: ']'})
+		lastIsSingle = true
+		parseContext.adv()
+	}
+
 	for parseContext.loc() < len(regexString) && regexString[parseContext.loc()] != ']' {
 		ch := regexString[parseContext.loc()]
 
-		if ch == '-' && parseContext.loc()+1 < len(regexString) {
+		if ch == '[' && parseContext.loc()+1 < len(regexString) && strings.IndexByte(":.=", regexString[parseContext.loc()+1]) >= 0 &&
+			dialect.supports(bracketClasses) {
+			classRanges, err := parseBracketClass(regexString, parseContext)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, classRanges...)
+			lastIsSingle = false
+		} else if ch == '\\' && parseContext.loc()+1 < len(regexString) && dialect.supports(classEscapes) {
+			if classRanges, negated, ok := escapeClass(regexString[parseContext.loc()+1], dialect); ok {
+				if negated {
+					classRanges = complementRanges(classRanges, parseContext.options.Unicode)
+				}
+				ranges = append(ranges, classRanges...)
+				lastIsSingle = false
+				parseContext.adv()
+			} else {
+				ch, err := parseBracketChar(regexString, parseContext)
+				if err != nil {
+					return err
+				}
+				ranges = append(ranges, runeRange{from: ch, to: ch})
+				lastIsSingle = true
+			}
+		} else if ch == '-' && parseContext.loc()+1 < len(regexString) {
 			nextChar := regexString[parseContext.loc()+1]
-			// if - is the first character OR is the last character, it's a literal
-			if len(pieces) == 0 || nextChar == ']' {
-				pieces = append(pieces, fmt.Sprintf("%c", ch))
+			// if - is the first character OR is the last character OR follows a range, it's a literal
+			if len(ranges) == 0 || nextChar == ']' || !lastIsSingle {
+				ranges = append(ranges, runeRange{from: '-', to: '-'})
+				lastIsSingle = true
 			} else {
 				parseContext.adv() // to process the nextChar's position
-				piece := pieces[len(pieces)-1]
-				if len(piece) == 1 {
-					prevChar := piece[0]
-					if prevChar <= nextChar {
-						pieces[len(pieces)-1] = fmt.Sprintf("%c%c", prevChar, nextChar)
-					} else {
-						return &RegexError{
-							Code:    SyntaxError,
-							Message: fmt.Sprintf("'%c-%c' range is invalid", prevChar, nextChar),
-							Pos:     parseContext.loc(),
-						}
+				to, err := parseBracketChar(regexString, parseContext)
+				if err != nil {
+					return err
+				}
+				from := ranges[len(ranges)-1].from
+				if from > to {
+					return &RegexError{
+						Code:    SyntaxError,
+						Message: fmt.Sprintf("'%c-%c' range is invalid", from, to),
+						Pos:     parseContext.loc(),
 					}
-				} else {
-					pieces = append(pieces, fmt.Sprintf("%c", ch))
 				}
+				ranges[len(ranges)-1].to = to
+				lastIsSingle = false
 			}
-		} else if ch == '\\' && parseContext.loc()+1 < len(regexString) {
-			nextChar := regexString[parseContext.adv()]
-			// TODO: some characters are special: \a does not just mean a, it means alarm ascii char etc.
-			// TODO: maybe in future, I'll implement that as well
-			// TODO: for now, all the escaped characters will be treated as literals
-			pieces = append(pieces, fmt.Sprintf("%c", nextChar))
 		} else {
-			pieces = append(pieces, fmt.Sprintf("%c", ch))
+			ch, err := parseBracketChar(regexString, parseContext)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, runeRange{from: ch, to: ch})
+			lastIsSingle = true
 		}
 		parseContext.adv()
 	}
 
-	if len(pieces) == 0 {
+	if len(ranges) == 0 {
 		return &RegexError{
 			Code:    SyntaxError,
 			Message: "Bracket should not be empty",
@@ -208,35 +306,208 @@ func parseBracket(regexString string, parseContext *parsingContext) *RegexError
 		}
 	}
 
-	uniqueCharacterPieces := map[uint8]bool{}
-	for _, piece := range pieces {
-		for s := piece[0]; s <= piece[len(piece)-1]; s++ {
-			uniqueCharacterPieces[s] = true
-		}
+	if parseContext.options.CaseInsensitive {
+		ranges = foldRanges(ranges, parseContext.options.Unicode)
+	}
+
+	// like the wildcard, the negated brackets do not match the newline
+	if tokenType == bracketNot {
+		ranges = append(ranges, parseContext.lineTerminators()...)
 	}
 
 	token := regexToken{
 		tokenType: tokenType,
-		value:     uniqueCharacterPieces,
+		value: characterClassPayload{
+			ranges:  normalizeRanges(ranges),
+			unicode: parseContext.options.Unicode,
+		},
 	}
-	parseContext.tokens = append(parseContext.tokens, token)
+	parseContext.push(token)
 
 	return nil
 }
 
+// parseBracketChar reads a single, possibly escaped, character of a bracket
+// and leaves the position at the last byte of it
+func parseBracketChar(regexString string, parseContext *parsingContext) (rune, *RegexError) {
+	ch := regexString[parseContext.loc()]
+	if ch >= utf8.RuneSelf && parseContext.options.Unicode {
+		return parseUnicodeChar(regexString, parseContext)
+	}
+
+	// the backslash is a literal inside the POSIX brackets
+	if ch != '\\' || parseContext.loc()+1 >= len(regexString) || !parseContext.options.Dialect.supports(bracketEscapes) {
+		return rune(ch), nil
+	}
+
+	nextChar := regexString[parseContext.adv()]
+	if nextChar == 'x' {
+		value, err := parseHexEscape(regexString, parseContext)
+		return rune(value), err
+	}
+	if nextChar >= utf8.RuneSelf && parseContext.options.Unicode {
+		return parseUnicodeChar(regexString, parseContext)
+	}
+	// TODO: some characters are special: \a does not just mean a, it means alarm ascii char etc.
+	// TODO: maybe in future, I'll implement that as well
+	// TODO: for now, all the escaped characters will be treated as literals
+	return rune(nextChar), nil
+}
+
+// parseBracketClass reads [:name:] inside the brackets, the position must be at the first '['.
+// it leaves the position at the last ']' of it. the Perl and RE2 syntaxes also have the negated [:^name:]
+func parseBracketClass(regexString string, parseContext *parsingContext) ([]runeRange, *RegexError) {
+	start := parseContext.loc()
+	kind := regexString[start+1]
+	if kind != ':' {
+		// [.x.] and [=x=] need the collation rules of a locale
+		return nil, &RegexError{
+			Code:    SyntaxError,
+			Message: fmt.Sprintf("Collating elements [%c %c] are not supported", kind, kind),
+			Pos:     start,
+		}
+	}
+
+	end := strings.Index(regexString[start+2:], ":]")
+	if end < 0 {
+		return nil, &RegexError{
+			Code:    SyntaxError,
+			Message: "Character class [: must be closed with :]",
+			Pos:     start,
+		}
+	}
+	name := regexString[start+2 : start+2+end]
+
+	negated := false
+	if strings.HasPrefix(name, "^") && (parseContext.options.Dialect == Perl || parseContext.options.Dialect == RE2) {
+		negated = true
+		name = name[1:]
+	}
+
+	ranges, ok := posixClasses[name]
+	if !ok || (name == "word" && !(parseContext.options.Dialect == Perl || parseContext.options.Dialect == RE2)) {
+		return nil, &RegexError{
+			Code:    SyntaxError,
+			Message: fmt.Sprintf("Unknown character class [:%s:]", name),
+			Pos:     start,
+		}
+	}
+	if negated {
+		ranges = complementRanges(ranges, parseContext.options.Unicode)
+	}
+
+	parseContext.advTo(start + 2 + end + 1)
+	return ranges, nil
+}
+
+// parseUnicodeChar decodes the UTF-8 encoded character at the current position
+// and leaves the position at the last byte of it
+func parseUnicodeChar(regexString string, parseContext *parsingContext) (rune, *RegexError) {
+	ch, size := utf8.DecodeRuneInString(regexString[parseContext.loc():])
+	if ch == utf8.RuneError && size <= 1 {
+		return 0, &RegexError{
+			Code:    SyntaxError,
+			Message: "Invalid UTF-8 encoding",
+			Pos:     parseContext.loc(),
+		}
+	}
+	parseContext.advTo(parseContext.loc() + size - 1)
+	return ch, nil
+}
+
+// parseHexEscape reads the two hex digits of \xHH, the position must be at the 'x'.
+// it leaves the position at the last digit
+func parseHexEscape(regexString string, parseContext *parsingContext) (uint8, *RegexError) {
+	start := parseContext.loc() + 1
+	if start+2 > len(regexString) {
+		return 0, &RegexError{
+			Code:    SyntaxError,
+			Message: "\\x must be followed by two hex digits",
+			Pos:     parseContext.loc(),
+		}
+	}
+
+	value, err := strconv.ParseUint(regexString[start:start+2], 16, 8)
+	if err != nil {
+		return 0, &RegexError{
+			Code:    SyntaxError,
+			Message: "\\x must be followed by two hex digits",
+			Pos:     parseContext.loc(),
+		}
+	}
+
+	parseContext.advTo(start + 1)
+	return uint8(value), nil
+}
+
 func parseGroup(regexString string, parseContext *parsingContext) *RegexError {
 	groupContext := parsingContext{
-		pos:    parseContext.loc(),
-		tokens: []regexToken{},
+		pos:        parseContext.loc(),
+		tokens:     []regexToken{},
+		groupNames: parseContext.groupNames,
+		options:    parseContext.options,
 	}
 
 	groupName := ""
+	balancedGroupName := ""
+	conditionName := ""
+	isAbsent := false
+	isConditional := false
 	if regexString[groupContext.loc()] == '?' {
-		if regexString[groupContext.adv()] == '<' {
+		dialect := parseContext.options.Dialect
+		if !dialect.supports(namedGroups) && !parseContext.options.Extended {
+			return unsupportedInDialect("(?...) group", dialect, groupContext.loc())
+		}
+
+		ch := regexString[groupContext.adv()]
+		if (ch == 'e' || ch == '!' || ch == '(') && !dialect.supports(groupExtensions) {
+			return unsupportedInDialect(fmt.Sprintf("(?%c...) group", ch), dialect, groupContext.loc())
+		}
+		if ch == 'P' && (dialect == Perl || dialect == RE2) && groupContext.loc()+1 < len(regexString) {
+			// (?P<name>...) is the same as (?<name>...)
+			ch = regexString[groupContext.adv()]
+		}
+
+		if ch == 'e' {
+			return parseApproximateFlag(regexString, parseContext)
+		} else if isInlineFlag(ch) {
+			if !dialect.supports(inlineFlags) {
+				return unsupportedInDialect("Inline flag", dialect, groupContext.loc())
+			}
+			return parseInlineFlags(regexString, parseContext, groupContext.loc())
+		} else if ch == '!' {
+			return parseFail(regexString, parseContext)
+		} else if parseContext.options.Extended && ch == '~' {
+			isAbsent = true
+		} else if !dialect.supports(namedGroups) {
+			return unsupportedInDialect("(?...) group", dialect, groupContext.loc())
+		} else if ch == '(' {
+			// (?(name)yes|no) conditional
+			isConditional = true
+			for groupContext.adv() < len(regexString) && regexString[groupContext.loc()] != ')' {
+				ch := regexString[groupContext.loc()]
+				conditionName += fmt.Sprintf("%c", ch)
+			}
+		} else if ch == '<' {
 			for regexString[groupContext.adv()] != '>' {
 				ch := regexString[groupContext.loc()]
 				groupName += fmt.Sprintf("%c", ch)
 			}
+			// (?<name-other>) and (?<-other>) are balancing groups
+			if name, other, isBalancing := strings.Cut(groupName, "-"); isBalancing {
+				if !dialect.supports(groupExtensions) {
+					return unsupportedInDialect("Balancing group", dialect, groupContext.loc())
+				}
+				if other == "" {
+					return &RegexError{
+						Code:    SyntaxError,
+						Message: "Balancing group must name the group it balances",
+						Pos:     groupContext.loc(),
+					}
+				}
+				groupName = name
+				balancedGroupName = other
+			}
 		} else {
 			return &RegexError{
 				Code:    SyntaxError,
@@ -248,6 +519,13 @@ func parseGroup(regexString string, parseContext *parsingContext) *RegexError {
 		groupContext.adv()
 	}
 
+	// the groups are numbered in the order of their opening parentheses,
+	// (?<-name>) only pops the other group, so it doesn't have a number
+	number := 0
+	if !isConditional && !isAbsent && (groupName != "" || balancedGroupName == "") {
+		number = parseContext.nextGroup(groupName)
+	}
+
 	for groupContext.loc() < len(regexString) && regexString[groupContext.loc()] != ')' {
 		ch := regexString[groupContext.loc()]
 		if err := processChar(regexString, &groupContext, ch); err != nil {
@@ -256,7 +534,7 @@ func parseGroup(regexString string, parseContext *parsingContext) *RegexError {
 		groupContext.adv()
 	}
 
-	if regexString[groupContext.loc()] != ')' {
+	if groupContext.loc() >= len(regexString) || regexString[groupContext.loc()] != ')' {
 		return &RegexError{
 			Code:    SyntaxError,
 			Message: "Group has not been properly closed",
@@ -267,19 +545,205 @@ func parseGroup(regexString string, parseContext *parsingContext) *RegexError {
 	token := regexToken{
 		tokenType: groupCaptured,
 		value: groupTokenPayload{
-			tokens: groupContext.tokens,
-			name:   groupName,
+			tokens:   groupContext.tokens,
+			number:   number,
+			name:     groupName,
+			balances: balancedGroupName,
 		},
 	}
+
+	if isConditional {
+		if conditionName == "" {
+			return &RegexError{
+				Code:    SyntaxError,
+				Message: "Conditional must name a group",
+				Pos:     groupContext.loc(),
+			}
+		}
+
+		// the alternation inside the conditional separates the yes and no branches
+		yes := regexToken{
+			tokenType: groupUncaptured,
+			value:     groupContext.tokens,
+		}
+		no := regexToken{
+			tokenType: groupUncaptured,
+			value:     []regexToken{},
+		}
+		if len(groupContext.tokens) == 1 && groupContext.tokens[0].tokenType == or {
+			branches := groupContext.tokens[0].value.([]regexToken)
+			yes, no = branches[0], branches[1]
+		}
+
+		token = regexToken{
+			tokenType: conditional,
+			value: conditionalPayload{
+				name: conditionName,
+				yes:  yes,
+				no:   no,
+			},
+		}
+	}
+
+	if isAbsent {
+		token = regexToken{
+			tokenType: absent,
+			value: regexToken{
+				tokenType: groupUncaptured,
+				value:     groupContext.tokens,
+			},
+		}
+	}
+
 	parseContext.push(token)
 	parseContext.advTo(groupContext.loc())
 	return nil
 }
 
+// parseLineBreak pushes the alternation of all the line break sequences, \r\n is tried first
+func parseLineBreak(parseContext *parsingContext) {
+	sequences := []string{"\r\n", "\n", "\v", "\f", "\r", "\u2028", "\u2029"}
+	if parseContext.options.Unicode {
+		sequences = append(sequences, "\u0085")
+	} else {
+		sequences = append(sequences, "\x85")
+	}
+
+	var token regexToken
+	for i := len(sequences) - 1; i >= 0; i-- {
+		var bytes []regexToken
+		for j := 0; j < len(sequences[i]); j++ {
+			bytes = append(bytes, regexToken{
+				tokenType: literal,
+				value:     sequences[i][j],
+			})
+		}
+		sequence := regexToken{
+			tokenType: groupUncaptured,
+			value:     bytes,
+		}
+
+		if i == len(sequences)-1 {
+			token = sequence
+		} else {
+			token = regexToken{
+				tokenType: or,
+				value:     []regexToken{sequence, token},
+			}
+		}
+	}
+	parseContext.push(token)
+}
+
+// isInlineFlag checks if the character can start the (?flags) group
+func isInlineFlag(ch uint8) bool {
+	return ch == '-' || ch == 'i' || ch == 'm' || ch == 's'
+}
+
+// parseInlineFlags turns the flags of (?flags) on, and the ones after the minus sign
+// in (?flags-flags) off, for the rest of the enclosing group
+func parseInlineFlags(regexString string, parseContext *parsingContext, pos int) *RegexError {
+	enable := true
+	for ; pos < len(regexString) && regexString[pos] != ')'; pos++ {
+		switch regexString[pos] {
+		case '-':
+			if !enable {
+				return &RegexError{
+					Code:    SyntaxError,
+					Message: "Inline flags can have only one minus sign",
+					Pos:     pos,
+				}
+			}
+			enable = false
+		case 'i':
+			parseContext.options.CaseInsensitive = enable
+		case 'm':
+			parseContext.options.Multiline = enable
+		case 's':
+			parseContext.options.DotAll = enable
+		default:
+			return &RegexError{
+				Code:    SyntaxError,
+				Message: fmt.Sprintf("Unknown inline flag '%c'", regexString[pos]),
+				Pos:     pos,
+			}
+		}
+	}
+
+	if pos >= len(regexString) {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Inline flags must be closed",
+			Pos:     pos,
+		}
+	}
+
+	parseContext.advTo(pos)
+	return nil
+}
+
+// parseFail parses (?!), an empty negative lookahead that never matches.
+// it's mostly useful in conditionals: (?(open)(?!)) fails if the 'open' group has captures left
+func parseFail(regexString string, parseContext *parsingContext) *RegexError {
+	closingPos := parseContext.loc() + 2 // skipping ? and !
+	if closingPos >= len(regexString) || regexString[closingPos] != ')' {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Lookaheads are not supported, only (?!) that never matches",
+			Pos:     parseContext.loc(),
+		}
+	}
+
+	token := regexToken{
+		tokenType: fail,
+	}
+	parseContext.push(token)
+	parseContext.advTo(closingPos)
+	return nil
+}
+
+// parseApproximateFlag parses (?e<=k), which allows up to k edits in the whole pattern.
+// since it applies to the whole pattern, it must be at the very beginning of it
+func parseApproximateFlag(regexString string, parseContext *parsingContext) *RegexError {
+	flagStart := parseContext.loc() - 1 // the position of the opening parenthesis
+	if flagStart != 0 {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "(?e<=k) must be at the beginning of the pattern",
+			Pos:     flagStart,
+		}
+	}
+
+	flagEnd := strings.IndexByte(regexString[flagStart:], ')')
+	if flagEnd == -1 || !strings.HasPrefix(regexString[flagStart:], "(?e<=") {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Approximate matching syntax is incorrect, it must be (?e<=k)",
+			Pos:     flagStart,
+		}
+	}
+	flagEnd += flagStart
+
+	maxEdits, err := strconv.Atoi(regexString[flagStart+len("(?e<=") : flagEnd])
+	if err != nil || maxEdits < 0 {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "The number of edits must be a non-negative number",
+			Pos:     flagStart,
+		}
+	}
+
+	parseContext.approximateEdits = maxEdits
+	parseContext.advTo(flagEnd)
+	return nil
+}
+
 func parseGroupUncaptured(regexString string, parseContext *parsingContext) *RegexError {
 	groupContext := parsingContext{
-		pos:    parseContext.loc(),
-		tokens: []regexToken{},
+		pos:        parseContext.loc(),
+		tokens:     []regexToken{},
+		groupNames: parseContext.groupNames,
+		options:    parseContext.options,
 	}
 
 	for groupContext.loc() < len(regexString) && regexString[groupContext.loc()] != ')' {
@@ -319,6 +783,10 @@ func parseQuantifier(ch uint8, parseContext *parsingContext) {
 }
 
 func parseLiteral(ch uint8, parseContext *parsingContext) {
+	if parseFoldedRune(rune(ch), parseContext) {
+		return
+	}
+
 	token := regexToken{
 		tokenType: literal,
 		value:     ch,
@@ -326,6 +794,56 @@ func parseLiteral(ch uint8, parseContext *parsingContext) {
 	parseContext.push(token)
 }
 
+// parseFoldedRune pushes a bracket of all the cases of the character in the case-insensitive mode,
+// it returns false if the character has no other case, and then nothing is pushed
+func parseFoldedRune(ch rune, parseContext *parsingContext) bool {
+	if !parseContext.options.CaseInsensitive {
+		return false
+	}
+
+	ranges := foldRanges([]runeRange{{from: ch, to: ch}}, parseContext.options.Unicode)
+	if len(ranges) == 1 && ranges[0].from == ranges[0].to {
+		return false
+	}
+
+	token := regexToken{
+		tokenType: bracket,
+		value: characterClassPayload{
+			ranges:  ranges,
+			unicode: parseContext.options.Unicode,
+		},
+	}
+	parseContext.push(token)
+	return true
+}
+
+// parseRune pushes a single character. in the Unicode mode, a character outside of ASCII
+// is a sequence of bytes, they are grouped so that a quantifier applies to all of them
+func parseRune(ch rune, parseContext *parsingContext) {
+	if ch < utf8.RuneSelf || !parseContext.options.Unicode {
+		parseLiteral(uint8(ch), parseContext)
+		return
+	}
+
+	if parseFoldedRune(ch, parseContext) {
+		return
+	}
+
+	var bytes []regexToken
+	for _, b := range []byte(string(ch)) {
+		bytes = append(bytes, regexToken{
+			tokenType: literal,
+			value:     b,
+		})
+	}
+
+	token := regexToken{
+		tokenType: groupUncaptured,
+		value:     bytes,
+	}
+	parseContext.push(token)
+}
+
 func processChar(regexString string, parseContext *parsingContext, ch uint8) *RegexError {
 	if ch == '(' {
 		parseContext.adv()
@@ -348,11 +866,29 @@ func processChar(regexString string, parseContext *parsingContext, ch uint8) *Re
 			return err
 		}
 	} else if isWildcard(ch) {
+		// the wildcard is a negated class: everything except the newline
 		token := regexToken{
 			tokenType: wildcard,
-			value:     ch,
+			value: characterClassPayload{
+				ranges:  parseContext.lineTerminators(),
+				unicode: parseContext.options.Unicode,
+			},
 		}
 		parseContext.push(token)
+	} else if parseContext.options.Extended && ch == '&' {
+		if err := parseIntersection(regexString, parseContext); err != nil {
+			return err
+		}
+	} else if parseContext.options.Extended && ch == '~' {
+		if err := parseComplement(regexString, parseContext); err != nil {
+			return err
+		}
+	} else if ch >= utf8.RuneSelf && parseContext.options.Unicode {
+		r, err := parseUnicodeChar(regexString, parseContext)
+		if err != nil {
+			return err
+		}
+		parseRune(r, parseContext)
 	} else if isLiteral(ch) {
 		parseLiteral(ch, parseContext)
 	} else if ch == '|' {
@@ -383,13 +919,103 @@ func processChar(regexString string, parseContext *parsingContext, ch uint8) *Re
 
 		token := regexToken{
 			tokenType: tokenType,
-			value:     ch,
+			value: anchorPayload{
+				multiline: parseContext.options.Multiline,
+				crlf:      parseContext.options.CRLF,
+			},
 		}
 		parseContext.push(token)
+	} else {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: fmt.Sprintf("Unexpected character 0x%02x, it must be escaped", ch),
+			Pos:     parseContext.loc(),
+		}
 	}
 	return nil
 }
 
+// parseIntersection works the same way as the alternation:
+// everything to the left of the ampersand in this specific "parsingContext"
+// is the left operand, the rest of the group is the right operand
+func parseIntersection(regexString string, parseContext *parsingContext) *RegexError {
+	if len(parseContext.tokens) == 0 {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Intersection must have a left operand",
+			Pos:     parseContext.loc(),
+		}
+	}
+
+	left := regexToken{
+		tokenType: groupUncaptured,
+		value:     parseContext.removeLast(len(parseContext.tokens)),
+	}
+
+	parseContext.adv() // to not get stuck in the ampersand char
+	if err := parseGroupUncaptured(regexString, parseContext); err != nil {
+		return err
+	}
+	right := parseContext.removeLast(1)[0]
+
+	if len(right.value.([]regexToken)) == 0 {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Intersection must have a right operand",
+			Pos:     parseContext.loc(),
+		}
+	}
+
+	token := regexToken{
+		tokenType: intersection,
+		value:     []regexToken{left, right},
+	}
+	parseContext.push(token)
+	return nil
+}
+
+// parseComplement applies the tilde to the single element that follows it,
+// e.g., ~ab is the complement of a followed by b, and ~(ab) is the complement of ab
+func parseComplement(regexString string, parseContext *parsingContext) *RegexError {
+	pos := parseContext.adv()
+	if pos >= len(regexString) {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Complement must be followed by an expression",
+			Pos:     pos,
+		}
+	}
+
+	ch := regexString[pos]
+	if isQuantifier(ch) || ch == '{' || ch == '|' || ch == '&' || ch == ')' {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: fmt.Sprintf("'%c' cannot be complemented", ch),
+			Pos:     pos,
+		}
+	}
+
+	tokenCount := len(parseContext.tokens)
+	if err := processChar(regexString, parseContext, ch); err != nil {
+		return err
+	}
+
+	if len(parseContext.tokens) == tokenCount {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Complement must be followed by an expression",
+			Pos:     pos,
+		}
+	}
+
+	token := regexToken{
+		tokenType: complement,
+		value:     parseContext.removeLast(1)[0],
+	}
+	parseContext.push(token)
+	return nil
+}
+
 func parseBoundedQuantifier(regexString string, parseContext *parsingContext) *RegexError {
 	startPos := parseContext.adv()
 	var endPos = parseContext.loc()
@@ -398,6 +1024,31 @@ func parseBoundedQuantifier(regexString string, parseContext *parsingContext) *R
 	}
 	parseContext.advTo(endPos)
 	expr := regexString[startPos:endPos]
+
+	if strings.HasPrefix(expr, "~") {
+		if !parseContext.options.Dialect.supports(approximate) {
+			return unsupportedInDialect("Approximate matching", parseContext.options.Dialect, startPos)
+		}
+		// {~k} allows up to k edits in the preceding element
+		maxEdits, err := strconv.Atoi(expr[1:])
+		if err != nil || maxEdits < 0 {
+			return &RegexError{
+				Code:    SyntaxError,
+				Message: "The number of edits must be a non-negative number",
+				Pos:     startPos,
+			}
+		}
+		token := regexToken{
+			tokenType: fuzzy,
+			value: fuzzyPayload{
+				maxEdits: maxEdits,
+				value:    parseContext.removeLast(1)[0],
+			},
+		}
+		parseContext.push(token)
+		return nil
+	}
+
 	pieces := strings.Split(expr, ",")
 
 	if len(pieces) == 0 {
@@ -444,6 +1095,15 @@ func parseBoundedQuantifier(regexString string, parseContext *parsingContext) *R
 		}
 	}
 
+	maxRepeat := parseContext.options.MaxRepeat
+	if maxRepeat > 0 && (start > maxRepeat || end > maxRepeat) {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: fmt.Sprintf("Quantifier bound exceeds the limit of %d", maxRepeat),
+			Pos:     startPos,
+		}
+	}
+
 	token := regexToken{
 		tokenType: quantifier,
 		value: quantifierPayload{
@@ -458,11 +1118,23 @@ func parseBoundedQuantifier(regexString string, parseContext *parsingContext) *R
 }
 
 func parseBackslash(regexString string, parseContext *parsingContext) *RegexError {
+	if parseContext.loc()+1 >= len(regexString) {
+		return &RegexError{
+			Code:    SyntaxError,
+			Message: "Pattern must not end with an escape character",
+			Pos:     parseContext.loc(),
+		}
+	}
+
 	nextChar := regexString[parseContext.loc()+1]
+	if (isNumeric(nextChar) || nextChar == 'k') && !parseContext.options.Dialect.supports(backreferences) {
+		return unsupportedInDialect("Backreference", parseContext.options.Dialect, parseContext.loc())
+	}
+
 	if isNumeric(nextChar) { // cares about the next single digit
 		token := regexToken{
 			tokenType: backReference,
-			value:     fmt.Sprintf("%c", nextChar),
+			value:     parseContext.backreferenceTo(fmt.Sprintf("%c", nextChar)),
 		}
 		parseContext.push(token)
 		parseContext.adv()
@@ -476,7 +1148,7 @@ func parseBackslash(regexString string, parseContext *parsingContext) *RegexErro
 			}
 			token := regexToken{
 				tokenType: backReference,
-				value:     groupName,
+				value:     parseContext.backreferenceTo(groupName),
 			}
 			parseContext.push(token)
 			parseContext.adv()
@@ -487,6 +1159,40 @@ func parseBackslash(regexString string, parseContext *parsingContext) *RegexErro
 				Pos:     parseContext.loc(),
 			}
 		}
+	} else if classRanges, negated, ok := escapeClass(nextChar, parseContext.options.Dialect); ok { // \d, \w, \s and the negations
+		if !parseContext.options.Dialect.supports(classEscapes) {
+			return unsupportedInDialect(fmt.Sprintf("\\%c", nextChar), parseContext.options.Dialect, parseContext.loc())
+		}
+		tokenType := regexTokenType(bracket)
+		if negated {
+			tokenType = bracketNot
+		}
+		token := regexToken{
+			tokenType: tokenType,
+			value: characterClassPayload{
+				ranges:  classRanges,
+				unicode: parseContext.options.Unicode,
+			},
+		}
+		parseContext.push(token)
+		parseContext.adv()
+	} else if nextChar == 'R' { // \R any line break sequence
+		parseLineBreak(parseContext)
+		parseContext.adv()
+	} else if nextChar == 'x' { // \xHH any byte value, or the character with that code in the Unicode mode
+		parseContext.adv()
+		value, err := parseHexEscape(regexString, parseContext)
+		if err != nil {
+			return err
+		}
+		parseRune(rune(value), parseContext)
+	} else if nextChar >= utf8.RuneSelf && parseContext.options.Unicode {
+		parseContext.adv()
+		r, err := parseUnicodeChar(regexString, parseContext)
+		if err != nil {
+			return err
+		}
+		parseRune(r, parseContext)
 	} else if _, canBeEscaped := mustBeEscapedCharacters[nextChar]; canBeEscaped {
 		token := regexToken{
 			tokenType: literal,
@@ -500,11 +1206,7 @@ func parseBackslash(regexString string, parseContext *parsingContext) *RegexErro
 		} else if nextChar == 't' {
 			nextChar = '\t'
 		}
-		token := regexToken{
-			tokenType: literal,
-			value:     nextChar,
-		}
-		parseContext.push(token)
+		parseLiteral(nextChar, parseContext)
 		parseContext.adv()
 	}
 
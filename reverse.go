@@ -0,0 +1,173 @@
+package rgx
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// reverseKey is a transition followed backward: the state it leads to and the symbol it consumes
+type reverseKey struct {
+	target *State
+	ch     symbol
+}
+
+// reversedNfa has the transitions of the NFA in the other direction, so that the input can be read
+// from the end toward the beginning. it's only made for the regular patterns without the operators of
+// the extended syntax, the other transitions depend on more than the character before the position
+type reversedNfa struct {
+	start       *State                  // reaching it means that a match starts at the position
+	terminals   []*State                // a match can end wherever these are
+	predecessor map[reverseKey][]*State // the states that reach the target with the literal or epsilon
+	classes     map[*State][]*State     // the states whose character class reaches the key
+	unicode     bool
+}
+
+func (s *State) reversed() (*reversedNfa, bool) {
+	if !s.regular {
+		return nil, false
+	}
+
+	reversible := true
+	r := &reversedNfa{
+		start:       s,
+		predecessor: map[reverseKey][]*State{},
+		classes:     map[*State][]*State{},
+		unicode:     s.unicode,
+	}
+	s.forEachState(func(state *State) {
+		if state.operator != nil {
+			reversible = false
+		}
+		if state.terminal {
+			r.terminals = append(r.terminals, state)
+		}
+		for ch, targets := range state.transitions {
+			if ch != epsilonChar {
+				// only the first target is followed, see nextStateWith
+				targets = targets[:1]
+			}
+			for _, target := range targets {
+				key := reverseKey{target: target, ch: ch}
+				r.predecessor[key] = append(r.predecessor[key], state)
+			}
+		}
+		if state.loop != nil {
+			key := reverseKey{target: state.loop, ch: epsilonChar}
+			r.predecessor[key] = append(r.predecessor[key], state)
+		}
+		if state.class != nil {
+			r.classes[state.class.target] = append(r.classes[state.class.target], state)
+		}
+	})
+	return r, reversible
+}
+
+// reverseRun reads the input backward with the reversed NFA on all of its paths at once,
+// like operandRun does forward. every path remembers the furthest end of the matches it comes from,
+// so the run finds the start of every match together with its longest end
+type reverseRun struct {
+	nfa     *reversedNfa
+	input   string
+	pending map[int]map[*State]int // the states each position continues from, with the end they come from
+}
+
+func newReverseRun(nfa *reversedNfa, inputString string) *reverseRun {
+	return &reverseRun{
+		nfa:     nfa,
+		input:   inputString,
+		pending: map[int]map[*State]int{},
+	}
+}
+
+func (r *reverseRun) add(pos int, state *State, end int) {
+	if r.pending[pos] == nil {
+		r.pending[pos] = map[*State]int{}
+	}
+	if previousEnd, ok := r.pending[pos][state]; !ok || end > previousEnd {
+		r.pending[pos][state] = end
+	}
+}
+
+// step starts the paths of the matches that end at pos, follows the epsilon transitions backward,
+// and moves the states back over the character before pos. it returns the longest end of the matches
+// that start at pos, and false if none does. the positions must be stepped in the decreasing order
+func (r *reverseRun) step(pos int) (int, bool) {
+	for _, terminal := range r.nfa.terminals {
+		r.add(pos, terminal, pos)
+	}
+	ends := r.pending[pos]
+	delete(r.pending, pos)
+
+	// a state keeps the furthest end it's reached with, so the paths are followed from the furthest one
+	var stack []*State
+	for state := range ends {
+		stack = append(stack, state)
+	}
+	sort.Slice(stack, func(i, j int) bool {
+		return ends[stack[i]] < ends[stack[j]]
+	})
+
+	reached := map[*State]int{}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.endOfText && pos != len(r.input) && !(s.multiline && s.isLineEnd(r.input, pos)) {
+			continue
+		}
+		if s.startOfText && pos != 0 && !(s.multiline && s.isLineStart(r.input, pos)) {
+			continue
+		}
+
+		end := ends[s]
+		if previousEnd, ok := reached[s]; ok && previousEnd >= end {
+			continue
+		}
+		reached[s] = end
+
+		for _, previous := range r.nfa.predecessor[reverseKey{target: s, ch: epsilonChar}] {
+			if previousEnd, ok := ends[previous]; !ok || end > previousEnd {
+				ends[previous] = end
+			}
+			stack = append(stack, previous)
+		}
+
+		if pos == 0 {
+			continue
+		}
+		for _, previous := range r.nfa.predecessor[reverseKey{target: s, ch: symbol(r.input[pos-1])}] {
+			r.add(pos-1, previous, end)
+		}
+		if classes := r.nfa.classes[s]; len(classes) > 0 {
+			charStart := pos - 1
+			if r.nfa.unicode {
+				_, size := utf8.DecodeLastRuneInString(r.input[:pos])
+				charStart = pos - size
+			}
+			for _, previous := range classes {
+				// the literal transitions are tried before the class, see State.check
+				if previous.nextStateWith(symbol(r.input[charStart])) != nil {
+					continue
+				}
+				if size, ok := previous.class.match(r.input, charStart); ok && charStart+size == pos {
+					r.add(charStart, previous, end)
+				}
+			}
+		}
+	}
+
+	end, ok := reached[r.nfa.start]
+	return end, ok
+}
+
+// furthestEnd returns the furthest end the unfinished paths come from, -1 if there are none
+func (r *reverseRun) furthestEnd() int {
+	furthest := -1
+	for _, ends := range r.pending {
+		for _, end := range ends {
+			if end > furthest {
+				furthest = end
+			}
+		}
+	}
+	return furthest
+}
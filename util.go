@@ -13,6 +13,7 @@ func dumpDotGraphForRegex(regexString string) {
 	memory := parsingContext{
 		pos:            0,
 		tokens:         []regexToken{},
+		groupNames:     &[]string{""},
 		capturedGroups: map[string]bool{},
 	}
 	regexError := parse(regexString, &memory)
@@ -52,6 +53,14 @@ func dot(s *State, processedStateForDot map[string]bool) {
 		}
 	}
 
+	if s.fuzzy != nil {
+		if s.fuzzy.enter {
+			fmt.Printf("%s [label=\"~%d\"]\n", thisStateName, s.fuzzy.maxEdits)
+		} else {
+			fmt.Printf("%s [label=\"~\"]\n", thisStateName)
+		}
+	}
+
 	if s.start {
 		fmt.Printf("%s [label=start]\n", thisStateName)
 	}
@@ -70,12 +79,12 @@ func dot(s *State, processedStateForDot map[string]bool) {
 
 	for char, states := range s.transitions {
 		var label string
-		if char == anyChar {
-			label = "any"
-		} else if char == epsilonChar {
+		if char == epsilonChar {
 			label = "ε"
 		} else if char == '\\' {
 			label = "backslash"
+		} else if char == '"' || char < ' ' || char > '~' {
+			label = fmt.Sprintf("\"0x%02x\"", char)
 		} else {
 			label = fmt.Sprintf("\"%c\"", char)
 		}
@@ -89,6 +98,63 @@ func dot(s *State, processedStateForDot map[string]bool) {
 		}
 	}
 
+	if s.loop != nil {
+		thatStateName := name(s.loop)
+		fmt.Printf("%s -> %s [label=\"loop\"]\n", thisStateName, thatStateName)
+		if _, ok := processedStateForDot[thatStateName]; !ok {
+			dot(s.loop, processedStateForDot)
+		}
+	}
+
+	if s.class != nil {
+		label := "class"
+		if s.class.negated {
+			label = "not class"
+		}
+
+		thatStateName := name(s.class.target)
+		fmt.Printf("%s -> %s [label=\"%s\"]\n", thisStateName, thatStateName, label)
+		if _, ok := processedStateForDot[thatStateName]; !ok {
+			dot(s.class.target, processedStateForDot)
+		}
+	}
+
+	if s.operator != nil {
+		var label string
+		switch s.operator.kind {
+		case intersection:
+			label = "and"
+		case complement:
+			label = "not"
+		case absent:
+			label = "absent"
+		}
+
+		thatStateName := name(s.operator.target)
+		fmt.Printf("%s -> %s [label=\"%s\"]\n", thisStateName, thatStateName, label)
+		if _, ok := processedStateForDot[thatStateName]; !ok {
+			dot(s.operator.target, processedStateForDot)
+		}
+
+		for _, operand := range s.operator.operands {
+			operandStateName := name(operand)
+			fmt.Printf("%s -> %s [style=dashed]\n", thisStateName, operandStateName)
+			if _, ok := processedStateForDot[operandStateName]; !ok {
+				dot(operand, processedStateForDot)
+			}
+		}
+	}
+
+	if s.condition != nil {
+		for label, branch := range map[string]*State{"yes": s.condition.yes, "no": s.condition.no} {
+			branchStateName := name(branch)
+			fmt.Printf("%s -> %s [label=\"%s(%s)\"]\n", thisStateName, branchStateName, label, s.condition.name)
+			if _, ok := processedStateForDot[branchStateName]; !ok {
+				dot(branch, processedStateForDot)
+			}
+		}
+	}
+
 	if s.backreference != nil {
 		thatStateName := name(s.backreference.target)
 		fmt.Printf("%s -> %s [label=\"g%s\"]\n", thisStateName, thatStateName, s.backreference.name)